@@ -4,8 +4,13 @@ go 1.23.5
 
 require (
 	github.com/fatih/structs v1.1.0
+	github.com/gorilla/websocket v1.4.2
 	github.com/knadh/koanf/parsers/toml v0.1.0
+	github.com/luthermonson/go-proxmox v0.2.1
+	github.com/mattn/go-sqlite3 v1.14.24
 	github.com/spf13/cobra v1.8.1
+	golang.org/x/crypto v0.36.0
+	golang.org/x/oauth2 v0.27.0
 )
 
 require (
@@ -14,10 +19,8 @@ require (
 	github.com/fatih/color v1.14.1 // indirect
 	github.com/fsnotify/fsnotify v1.7.0 // indirect
 	github.com/go-viper/mapstructure/v2 v2.2.1 // indirect
-	github.com/gorilla/websocket v1.4.2 // indirect
 	github.com/jinzhu/copier v0.3.4 // indirect
 	github.com/knadh/koanf/maps v0.1.1 // indirect
-	github.com/luthermonson/go-proxmox v0.2.1 // indirect
 	github.com/magefile/mage v1.14.0 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.19 // indirect
@@ -27,7 +30,7 @@ require (
 	github.com/pelletier/go-toml v1.9.5 // indirect
 	github.com/rivo/uniseg v0.2.0 // indirect
 	github.com/theckman/yacspin v0.13.12 // indirect
-	golang.org/x/sys v0.21.0 // indirect
+	golang.org/x/sys v0.31.0 // indirect
 	gopkg.in/djherbis/times.v1 v1.2.0 // indirect
 )
 
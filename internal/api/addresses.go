@@ -0,0 +1,176 @@
+package api
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/luthermonson/go-proxmox"
+	"github.com/rs/zerolog/log"
+)
+
+// Addresses are the IP addresses an instance's interfaces currently have.
+type Addresses struct {
+	IPv4 []string `json:"ipv4"`
+	IPv6 []string `json:"ipv6"`
+}
+
+type cachedAddresses struct {
+	addresses Addresses
+	fetched   time.Time
+}
+
+// Remembers instance addresses for a short while. Finding them means asking every running instance (and waiting on
+// the guest agent for VMs) so doing it on every request would make listing instances slow.
+type addressCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[uint64]cachedAddresses
+}
+
+func newAddressCache(ttl time.Duration) *addressCache {
+	return &addressCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[uint64]cachedAddresses{},
+	}
+}
+
+func (c *addressCache) get(id uint64) (Addresses, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[id]
+	if !ok || c.now().Sub(entry.fetched) >= c.ttl {
+		return Addresses{}, false
+	}
+
+	return entry.addresses, true
+}
+
+func (c *addressCache) set(id uint64, addresses Addresses) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[id] = cachedAddresses{addresses: addresses, fetched: c.now()}
+}
+
+// Drops an instance's addresses, for when they are known to have changed (ex. the instance was stopped).
+func (c *addressCache) forget(id uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, id)
+}
+
+// Sorts addresses into IPv4 and IPv6, skipping loopback and link-local addresses and anything that isn't an address.
+// Proxmox reports some addresses in CIDR notation (ex. "10.0.0.5/24") and some without.
+func sortAddresses(rawAddresses []string) Addresses {
+	addresses := Addresses{IPv4: []string{}, IPv6: []string{}}
+
+	for _, raw := range rawAddresses {
+		prefix, err := netip.ParsePrefix(raw)
+		addr := prefix.Addr()
+		if err != nil {
+			addr, err = netip.ParseAddr(raw)
+			if err != nil {
+				continue
+			}
+		}
+
+		if addr.IsLoopback() || addr.IsLinkLocalUnicast() {
+			continue
+		}
+
+		if addr.Is4() {
+			addresses.IPv4 = append(addresses.IPv4, addr.String())
+		} else {
+			addresses.IPv6 = append(addresses.IPv6, addr.String())
+		}
+	}
+
+	return addresses
+}
+
+// Asks Proxmox for the addresses of an instance's interfaces. Containers report them through the interfaces endpoint
+// and VMs through the QEMU guest agent, so VMs without a running agent return an error.
+func (api *APIContext) lookupAddresses(ctx context.Context, resource *proxmox.ClusterResource) (Addresses, error) {
+	rawAddresses := []string{}
+
+	if resource.Type == "lxc" {
+		var interfaces proxmox.ContainerInterfaces
+		err := api.Client.Get(ctx, instancePath(resource)+"/interfaces", &interfaces)
+		if err != nil {
+			return Addresses{}, err
+		}
+
+		for _, iface := range interfaces {
+			rawAddresses = append(rawAddresses, iface.Inet, iface.Inet6)
+		}
+
+		return sortAddresses(rawAddresses), nil
+	}
+
+	var agentResponse struct {
+		Result []*proxmox.AgentNetworkIface `json:"result"`
+	}
+	err := api.Client.Get(ctx, instancePath(resource)+"/agent/network-get-interfaces", &agentResponse)
+	if err != nil {
+		return Addresses{}, err
+	}
+
+	for _, iface := range agentResponse.Result {
+		for _, address := range iface.IPAddresses {
+			rawAddresses = append(rawAddresses, address.IPAddress)
+		}
+	}
+
+	return sortAddresses(rawAddresses), nil
+}
+
+// Returns the addresses of an instance, from the cache if they were looked up recently. Only running instances have
+// addresses. Failing to look them up isn't treated as an error since a VM that is still booting or has no guest agent
+// simply doesn't have any to report yet; those are cached too so we don't keep waiting on an agent that isn't there.
+func (api *APIContext) instanceAddresses(ctx context.Context, resource *proxmox.ClusterResource) Addresses {
+	if resource.Status != "running" {
+		api.addresses.forget(resource.VMID)
+		return Addresses{IPv4: []string{}, IPv6: []string{}}
+	}
+
+	addresses, ok := api.addresses.get(resource.VMID)
+	if ok {
+		return addresses
+	}
+
+	addresses, err := api.lookupAddresses(ctx, resource)
+	if err != nil {
+		log.Debug().Err(err).Uint64("id", resource.VMID).Msg("could not get instance addresses")
+		addresses = Addresses{IPv4: []string{}, IPv6: []string{}}
+	}
+
+	api.addresses.set(resource.VMID, addresses)
+
+	return addresses
+}
+
+// Fills in the addresses of many instances at once. Lookups are made concurrently since each one can take a while.
+func (api *APIContext) fillAddresses(ctx context.Context, instances []Instance) {
+	var wg sync.WaitGroup
+	for i := range instances {
+		wg.Add(1)
+		go func(instance *Instance) {
+			defer wg.Done()
+			instance.Addresses = api.instanceAddresses(ctx, &proxmox.ClusterResource{
+				VMID:   instance.ID,
+				Node:   instance.Node,
+				Type:   instance.Kind.resourceType(),
+				Status: instance.Status,
+			})
+		}(&instances[i])
+	}
+
+	wg.Wait()
+}
@@ -2,19 +2,25 @@ package api
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/tls"
 	"encoding/json"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/clintjedwards/rc3/internal/conf"
+	"github.com/clintjedwards/rc3/internal/frontend"
+	"github.com/clintjedwards/rc3/internal/placement"
+	"github.com/clintjedwards/rc3/internal/storage"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/luthermonson/go-proxmox"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/oauth2"
 )
 
 func requestIDMiddleware(next http.Handler) http.Handler {
@@ -27,11 +33,68 @@ func requestIDMiddleware(next http.Handler) http.Handler {
 
 // Data kept for the lifetime of the API.
 type APIContext struct {
-	Client        *proxmox.Client
-	ProxmoxConfig *conf.Proxmox
+	Client            *proxmox.Client
+	ProxmoxConfig     *conf.Proxmox
+	AuthConfig        *conf.Auth
+	PlacementConfig   *conf.Placement
+	SSHKeysConfig     *conf.SSHKeys
+	QuotasConfig      *conf.Quotas
+	ExpiryConfig      *conf.Expiry
+	IdleConfig        *conf.Idle
+	SnapshotsConfig   *conf.Snapshots
+	BackupsConfig     *conf.Backups
+	DevelopmentConfig *conf.Development
+	ServerConfig      *conf.Server
+	Sizes             []conf.Size
+	Images            []conf.Image
+	DB                *storage.DB
+
+	oauthConfig       *oauth2.Config
+	sessionKey        []byte
+	placementStrategy placement.Strategy
+
+	// Tasks that are still being worked on, so requests can wait on them.
+	tasksMu      sync.Mutex
+	runningTasks map[string]chan struct{}
+
+	// Held while checking a recurser's quota and claiming resources against it so concurrent requests can't both fit
+	// into the same headroom.
+	quotaMu sync.Mutex
+
+	// Held while changing when an instance expires so the reaper doesn't act on an expiry that is being extended.
+	expiryMu sync.Mutex
+
+	// Held while counting a recurser's snapshots and recording a new one so concurrent requests can't go over the
+	// limit.
+	snapshotsMu sync.Mutex
+
+	// Held per instance while backing it up or restoring it in place, so each backup can tell which archive is its
+	// own and never archives an instance that is half way through being restored.
+	backupLocks instanceLocks
+
+	addresses *addressCache
+}
+
+// Opens the database and brings its schema up to date.
+func newStorage(conf *conf.Storage) *storage.DB {
+	db, err := storage.New(conf.Path)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not open database")
+	}
+
+	applied, err := db.Migrate()
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not migrate database")
+	}
+
+	log.Info().Str("path", conf.Path).Strs("applied_migrations", applied).Msg("opened database")
+
+	return db
 }
 
-func newAPIContext(proxmoxConf *conf.Proxmox) *APIContext {
+func newAPIContext(conf *conf.API) *APIContext {
+	proxmoxConf := conf.Proxmox
+
 	var client *proxmox.Client
 
 	if proxmoxConf.UseTLS {
@@ -61,10 +124,75 @@ func newAPIContext(proxmoxConf *conf.Proxmox) *APIContext {
 		Str("version", version.Version).
 		Msg("successfully connected to Proxmox")
 
-	return &APIContext{
-		Client:        client,
-		ProxmoxConfig: proxmoxConf,
+	api := &APIContext{
+		Client:            client,
+		ProxmoxConfig:     proxmoxConf,
+		AuthConfig:        conf.Auth,
+		PlacementConfig:   conf.Placement,
+		SSHKeysConfig:     conf.SSHKeys,
+		QuotasConfig:      conf.Quotas,
+		ExpiryConfig:      conf.Expiry,
+		IdleConfig:        conf.Idle,
+		SnapshotsConfig:   conf.Snapshots,
+		BackupsConfig:     conf.Backups,
+		DevelopmentConfig: conf.Development,
+		ServerConfig:      conf.Server,
+		Sizes:             conf.Sizes,
+		Images:            conf.Images,
+		DB:                newStorage(conf.Storage),
+		runningTasks:      map[string]chan struct{}{},
+		addresses:         newAddressCache(conf.Server.AddressCacheTTL),
+	}
+
+	api.failInterruptedTasks()
+
+	err = validateSizes(conf.Sizes)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid size catalog")
+	}
+
+	err = validateImages(conf.Images)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid image catalog")
+	}
+
+	err = validateExpiry(conf.Expiry)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid expiry settings")
+	}
+
+	err = validateIdle(conf.Idle)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid idle settings")
+	}
+
+	err = validateBackups(conf.Backups)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid backup settings")
+	}
+
+	api.placementStrategy, err = placement.NewStrategy(conf.Placement.Strategy, conf.Placement.PinnedNode)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid placement settings")
+	}
+
+	api.oauthConfig = newOAuthConfig(api)
+	api.sessionKey = []byte(conf.Auth.SessionKey)
+
+	if len(api.sessionKey) == 0 {
+		api.sessionKey = make([]byte, 32)
+		if _, err := rand.Read(api.sessionKey); err != nil {
+			log.Fatal().Err(err).Msg("could not generate session key")
+		}
+		log.Warn().Msg("no session key configured; generated a temporary one. Sessions will not survive a restart")
+	}
+
+	if conf.Development.BypassAuth {
+		log.Warn().Str("recurser_id", conf.Development.FakeRecurserID).
+			Msg("authentication is bypassed; all requests will be made as the fake development recurser")
 	}
+
+	return api
 }
 
 type RouteEntry struct {
@@ -72,7 +200,9 @@ type RouteEntry struct {
 	Router  func(r chi.Router)
 }
 
-func startServer(conf *conf.API, routes ...RouteEntry) {
+func startServer(conf *conf.API, authMiddleware func(http.Handler) http.Handler, authRoutes RouteEntry,
+	routes ...RouteEntry,
+) {
 	router := chi.NewRouter()
 
 	router.Use(middleware.RequestID) // Auto-generate a request ID for us.
@@ -80,16 +210,21 @@ func startServer(conf *conf.API, routes ...RouteEntry) {
 	router.Use(middleware.RealIP)    // Automatically insert the correct external IP.
 	router.Use(middleware.Recoverer) // Don't let panics bring down the entire service.
 	router.Use(loggingMiddleware)    // Log requests
+	router.Route(authRoutes.Pattern, authRoutes.Router)
+	router.Get("/console/{id}", frontend.Console) // Connects to /api/instances/{id}/console from the browser.
+	router.Handle("/console/assets/*", frontend.Assets())
 	router.Route("/api", func(r chi.Router) {
+		r.Use(authMiddleware) // Every API route requires a recurser to be logged in.
 		for _, route := range routes {
 			r.Route(route.Pattern, route.Router)
 		}
 	})
 
 	httpServer := http.Server{
-		Addr:         conf.Server.Host,
-		Handler:      router,
-		WriteTimeout: 15 * time.Second,
+		Addr:    conf.Server.Host,
+		Handler: router,
+		// Requests made with ?wait=true are held open for up to MaxTaskWait before anything is written.
+		WriteTimeout: 15*time.Second + conf.Server.MaxTaskWait,
 		ReadTimeout:  15 * time.Second,
 	}
 
@@ -120,10 +255,28 @@ func startServer(conf *conf.API, routes ...RouteEntry) {
 }
 
 func StartAPIServer(conf *conf.API) {
-	api := newAPIContext(conf.Proxmox)
+	api := newAPIContext(conf)
+
+	go newReaper(api).run(conf.Expiry.ReapInterval)
+
+	if conf.Idle.Window > 0 {
+		go newIdleMonitor(api).run(conf.Idle.SampleInterval)
+	}
 
-	startServer(conf,
+	if conf.Backups.Storage != "" {
+		go newBackupScheduler(api).run(conf.Backups.ScheduleInterval)
+	}
+
+	startServer(conf, api.authMiddleware, api.authRouter(),
 		api.instancesRouter(), // /api/instances
+		api.tokensRouter(),    // /api/tokens
+		api.tasksRouter(),     // /api/tasks
+		api.sizesRouter(),     // /api/sizes
+		api.imagesRouter(),    // /api/images
+		api.sshKeysRouter(),   // /api/keys
+		api.meRouter(),        // /api/me
+		api.quotasRouter(),    // /api/quotas
+		api.backupsRouter(),   // /api/backups
 	)
 }
 
@@ -147,14 +300,6 @@ func loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-type AuthContext struct {
-	RecurserID string
-}
-
-func CheckAuth(r *http.Request) AuthContext {
-	return AuthContext{}
-}
-
 type ErrorResponse struct {
 	Error        string `json:"error"`         // Short description
 	ErrorDetails string `json:"error_details"` // More detailed explanation
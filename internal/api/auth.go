@@ -0,0 +1,415 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/oauth2"
+)
+
+const (
+	sessionCookieName    = "rc3_session"
+	oauthStateCookieName = "rc3_oauth_state"
+	oauthStateTTL        = 10 * time.Minute
+)
+
+// AuthContext describes who is making the current request. It is populated by the auth middleware for every request
+// under /api.
+type AuthContext struct {
+	RecurserID string
+	Name       string
+	IsAdmin    bool
+}
+
+type authContextKey struct{}
+
+// CheckAuth returns the identity attached to the request by the auth middleware. Handlers mounted under /api can
+// rely on this always being populated since unauthenticated requests are rejected before they reach them.
+func CheckAuth(r *http.Request) AuthContext {
+	authCtx, _ := r.Context().Value(authContextKey{}).(AuthContext)
+	return authCtx
+}
+
+func withAuthContext(r *http.Request, authCtx AuthContext) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), authContextKey{}, authCtx))
+}
+
+func newOAuthConfig(api *APIContext) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     api.AuthConfig.ClientID,
+		ClientSecret: api.AuthConfig.ClientSecret,
+		RedirectURL:  api.AuthConfig.RedirectURL,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  api.AuthConfig.AuthURL,
+			TokenURL: api.AuthConfig.TokenURL,
+		},
+	}
+}
+
+// Sessions are stateless; the cookie carries who the recurser is and when the session expires, and is signed with
+// the session key so it can't be tampered with.
+type session struct {
+	RecurserID string `json:"recurser_id"`
+	Name       string `json:"name"`
+	Expires    int64  `json:"expires"`
+}
+
+func (api *APIContext) signSession(s session) (string, error) {
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+
+	mac := hmac.New(sha256.New, api.sessionKey)
+	mac.Write([]byte(encodedPayload))
+	signature := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+	return encodedPayload + "." + signature, nil
+}
+
+func (api *APIContext) verifySession(value string) (session, error) {
+	encodedPayload, signature, found := strings.Cut(value, ".")
+	if !found {
+		return session{}, fmt.Errorf("malformed session")
+	}
+
+	providedMAC, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return session{}, fmt.Errorf("malformed session signature: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, api.sessionKey)
+	mac.Write([]byte(encodedPayload))
+	if !hmac.Equal(providedMAC, mac.Sum(nil)) {
+		return session{}, fmt.Errorf("invalid session signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return session{}, fmt.Errorf("malformed session payload: %w", err)
+	}
+
+	var s session
+	if err := json.Unmarshal(payload, &s); err != nil {
+		return session{}, fmt.Errorf("malformed session payload: %w", err)
+	}
+
+	if time.Now().Unix() > s.Expires {
+		return session{}, fmt.Errorf("session expired")
+	}
+
+	return s, nil
+}
+
+// Figures out who is making the request. Scripts and the CLI authenticate with a personal API token in the
+// Authorization header, browsers with a session cookie.
+func (api *APIContext) authenticate(r *http.Request) (AuthContext, error) {
+	if api.DevelopmentConfig.BypassAuth {
+		return AuthContext{
+			RecurserID: api.DevelopmentConfig.FakeRecurserID,
+			Name:       api.DevelopmentConfig.FakeRecurserName,
+		}, nil
+	}
+
+	if header := r.Header.Get("Authorization"); header != "" {
+		secret, found := strings.CutPrefix(header, "Bearer ")
+		if !found {
+			return AuthContext{}, fmt.Errorf("authorization header must be in the format 'Bearer <token>'")
+		}
+
+		authCtx, err := api.authenticateToken(secret)
+		if err != nil {
+			return AuthContext{}, fmt.Errorf("could not verify token: %w", err)
+		}
+
+		return authCtx, nil
+	}
+
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return AuthContext{}, fmt.Errorf("no token or session found; log in at /auth/login")
+	}
+
+	s, err := api.verifySession(cookie.Value)
+	if err != nil {
+		return AuthContext{}, fmt.Errorf("could not verify session: %w", err)
+	}
+
+	return AuthContext{
+		RecurserID: s.RecurserID,
+		Name:       s.Name,
+	}, nil
+}
+
+// Authenticates every request under /api. Requests without a valid token or session are rejected with a 401.
+func (api *APIContext) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authCtx, err := api.authenticate(r)
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		authCtx.IsAdmin = slices.Contains(api.AuthConfig.Admins, authCtx.RecurserID)
+
+		next.ServeHTTP(w, withAuthContext(r, authCtx))
+	})
+}
+
+func (api *APIContext) authRouter() RouteEntry {
+	router := func(router chi.Router) {
+		router.Get("/login", api.login)
+		router.Get("/callback", api.loginCallback)
+		router.Post("/logout", api.logout)
+		router.Get("/cli", api.cliLogin)
+		router.Post("/cli", api.createCLIToken)
+	}
+
+	return RouteEntry{
+		Pattern: "/auth",
+		Router:  router,
+	}
+}
+
+// Only allow redirects back to paths on this host so the login flow can't be used as an open redirect.
+func safeRedirectPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return "/"
+	}
+
+	return path
+}
+
+func (api *APIContext) login(w http.ResponseWriter, r *http.Request) {
+	stateBytes := make([]byte, 32)
+	if _, err := rand.Read(stateBytes); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not generate oauth state: %v", err))
+		return
+	}
+
+	state := base64.RawURLEncoding.EncodeToString(stateBytes)
+	redirect := safeRedirectPath(r.URL.Query().Get("redirect"))
+
+	// The state cookie lets us confirm the callback belongs to a login we started and remembers where to send the
+	// recurser afterwards.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookieName,
+		Value:    state + "|" + redirect,
+		Path:     "/auth",
+		MaxAge:   int(oauthStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   api.AuthConfig.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, api.oauthConfig.AuthCodeURL(state), http.StatusFound)
+}
+
+type recurseProfile struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+func (api *APIContext) fetchProfile(ctx context.Context, token *oauth2.Token) (*recurseProfile, error) {
+	client := api.oauthConfig.Client(ctx, token)
+
+	resp, err := client.Get(api.AuthConfig.ProfileURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("profile endpoint returned %s", resp.Status)
+	}
+
+	var profile recurseProfile
+	if err := json.NewDecoder(resp.Body).Decode(&profile); err != nil {
+		return nil, err
+	}
+
+	if profile.ID == 0 {
+		return nil, fmt.Errorf("profile endpoint returned no recurser id")
+	}
+
+	return &profile, nil
+}
+
+func (api *APIContext) loginCallback(w http.ResponseWriter, r *http.Request) {
+	stateCookie, err := r.Cookie(oauthStateCookieName)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "missing oauth state; start the login again at /auth/login")
+		return
+	}
+
+	// Clear the state cookie no matter how this turns out; it is single use.
+	http.SetCookie(w, &http.Cookie{
+		Name:   oauthStateCookieName,
+		Path:   "/auth",
+		MaxAge: -1,
+	})
+
+	expectedState, redirect, _ := strings.Cut(stateCookie.Value, "|")
+	if expectedState == "" || r.URL.Query().Get("state") != expectedState {
+		writeError(w, http.StatusBadRequest, "oauth state mismatch; start the login again at /auth/login")
+		return
+	}
+
+	if errStr := r.URL.Query().Get("error"); errStr != "" {
+		writeError(w, http.StatusUnauthorized, fmt.Sprintf("login was not completed: %s", errStr))
+		return
+	}
+
+	token, err := api.oauthConfig.Exchange(r.Context(), r.URL.Query().Get("code"))
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, fmt.Sprintf("could not exchange authorization code: %v", err))
+		return
+	}
+
+	profile, err := api.fetchProfile(r.Context(), token)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Sprintf("could not look up recurser profile: %v", err))
+		return
+	}
+
+	expires := time.Now().Add(api.AuthConfig.SessionDuration)
+
+	sessionValue, err := api.signSession(session{
+		RecurserID: strconv.FormatInt(profile.ID, 10),
+		Name:       profile.Name,
+		Expires:    expires.Unix(),
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not create session: %v", err))
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    sessionValue,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   api.AuthConfig.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	log.Info().Int64("recurser_id", profile.ID).Str("name", profile.Name).Msg("recurser logged in")
+
+	http.Redirect(w, r, safeRedirectPath(redirect), http.StatusFound)
+}
+
+func (api *APIContext) logout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   api.AuthConfig.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// The landing page for `rc3 login`. Loading it only shows a button; the token is minted by the form it posts, so
+// reloads, prefetches and links from other sites can't quietly pile up tokens.
+func (api *APIContext) cliLogin(w http.ResponseWriter, r *http.Request) {
+	authCtx, err := api.authenticate(r)
+	if err != nil {
+		http.Redirect(w, r, "/auth/login?redirect=/auth/cli", http.StatusFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `<!doctype html>
+<html lang="en">
+  <head><meta charset="utf-8" /><title>Log in to the RC3 CLI</title></head>
+  <body>
+    <p>Logged in as %s.</p>
+    <form method="post" action="/auth/cli">
+      <button type="submit">Create a token for the RC3 CLI</button>
+    </form>
+  </body>
+</html>
+`, html.EscapeString(authCtx.Name))
+}
+
+// Reports whether a request came from a page on this host. Browsers mark where requests come from with
+// Sec-Fetch-Site, or at least Origin on POSTs; requests with neither are refused since they can't be told apart from
+// a forged one.
+func sameOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site == "same-origin"
+	}
+
+	origin, err := url.Parse(r.Header.Get("Origin"))
+	if err != nil || origin.Host == "" {
+		return false
+	}
+
+	return origin.Host == r.Host
+}
+
+// Mints a new personal API token for the logged in recurser and displays it so it can be pasted into the CLI.
+func (api *APIContext) createCLIToken(w http.ResponseWriter, r *http.Request) {
+	authCtx, err := api.authenticate(r)
+	if err != nil {
+		http.Redirect(w, r, "/auth/login?redirect=/auth/cli", http.StatusFound)
+		return
+	}
+
+	if !sameOrigin(r) {
+		writeError(w, http.StatusForbidden, "tokens can only be created from the RC3 login page; visit /auth/cli")
+		return
+	}
+
+	name := fmt.Sprintf("rc3-cli-%d", time.Now().Unix())
+
+	_, secret, err := api.issueToken(authCtx, name, 0)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not create token: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "Logged in as %s.\n\nYour new RC3 token %q is below. It will not be shown again.\n\n%s\n\n"+
+		"Paste it into the `rc3 login` prompt or run: rc3 login --token <token>\n", authCtx.Name, name, secret)
+}
+
+type GetMeResponse struct {
+	RecurserID string `json:"recurser_id"`
+	Name       string `json:"name"`
+	IsAdmin    bool   `json:"is_admin"`
+}
+
+// Tells the caller who they are logged in as, which lets clients pick out their own instances.
+func (api *APIContext) getMe(w http.ResponseWriter, r *http.Request) {
+	authCtx := CheckAuth(r)
+
+	writeResponse(w, http.StatusOK, GetMeResponse{
+		RecurserID: authCtx.RecurserID,
+		Name:       authCtx.Name,
+		IsAdmin:    authCtx.IsAdmin,
+	})
+}
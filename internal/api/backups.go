@@ -0,0 +1,1087 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clintjedwards/rc3/internal/conf"
+	"github.com/clintjedwards/rc3/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"github.com/luthermonson/go-proxmox"
+	"github.com/rs/zerolog/log"
+)
+
+func (api *APIContext) backupsRouter() RouteEntry {
+	router := func(router chi.Router) {
+		router.Get("/", api.listMyBackups)
+		router.Get("/{backup_id}", api.getBackup)
+		router.Delete("/{backup_id}", api.deleteBackup)
+		router.Post("/{backup_id}/restore", api.restoreBackup)
+	}
+
+	return RouteEntry{
+		Pattern: "/backups",
+		Router:  router,
+	}
+}
+
+const bytesPerGB = 1024 * 1024 * 1024
+
+// How many scheduled backups a policy keeps when its owner doesn't say, as long as the config allows that many.
+const defaultBackupRetention = 7
+
+type BackupSchedule string
+
+const (
+	BackupScheduleDaily  BackupSchedule = "daily"
+	BackupScheduleWeekly BackupSchedule = "weekly"
+)
+
+func (s *BackupSchedule) UnmarshalJSON(b []byte) error {
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return err
+	}
+
+	switch BackupSchedule(str) {
+	case BackupScheduleDaily, BackupScheduleWeekly:
+		*s = BackupSchedule(str)
+		return nil
+	default:
+		return fmt.Errorf("invalid BackupSchedule: %s", str)
+	}
+}
+
+// How long the schedule waits between backups.
+func (s BackupSchedule) interval() time.Duration {
+	if s == BackupScheduleWeekly {
+		return 7 * 24 * time.Hour
+	}
+
+	return 24 * time.Hour
+}
+
+// Works out when a policy should next take a backup given when it last ran. Policies that have never run are due
+// straight away (0) so an instance is protected as soon as it has a policy.
+func nextBackupRun(schedule BackupSchedule, lastRun int64) int64 {
+	if lastRun == 0 {
+		return 0
+	}
+
+	return lastRun + int64(schedule.interval().Seconds())
+}
+
+// Picks out the scheduled backups that fall outside a policy's retention from an instance's backups, which must be
+// newest first. Backups taken on demand are left for their owner to delete.
+func backupsToPrune(backups []storage.Backup, retention int) []storage.Backup {
+	prune := []storage.Backup{}
+	kept := 0
+
+	for _, backup := range backups {
+		if !backup.Scheduled {
+			continue
+		}
+
+		if kept < retention {
+			kept++
+			continue
+		}
+
+		prune = append(prune, backup)
+	}
+
+	return prune
+}
+
+// Only checked when backups are turned on, since without a storage none of the other settings are used.
+func validateBackups(backups *conf.Backups) error {
+	if backups.Storage == "" {
+		return nil
+	}
+
+	switch backups.Mode {
+	case "snapshot", "suspend", "stop":
+	default:
+		return fmt.Errorf("mode must be one of \"snapshot\", \"suspend\" or \"stop\"")
+	}
+
+	if backups.MaxRetention <= 0 {
+		return fmt.Errorf("max_retention must be positive")
+	}
+
+	if backups.ScheduleInterval <= 0 {
+		return fmt.Errorf("schedule_interval must be positive")
+	}
+
+	return nil
+}
+
+// Writes an error and returns false if backups are turned off.
+func (api *APIContext) requireBackups(w http.ResponseWriter) bool {
+	if api.BackupsConfig.Storage == "" {
+		writeError(w, http.StatusNotImplemented, "backups are turned off; no backup storage is configured")
+		return false
+	}
+
+	return true
+}
+
+// Backup is a vzdump backup of an instance. Backups are kept after their instance is deleted so it can be brought
+// back.
+type Backup struct {
+	ID           int64        `json:"id"`
+	InstanceID   uint64       `json:"instance_id"`
+	InstanceName string       `json:"instance_name"`
+	Kind         InstanceType `json:"kind"`
+	Size         InstanceSize `json:"size"`
+	Recurser     string       `json:"recurser"` // Who the backup counts against.
+	Volume       string       `json:"volume"`   // ex. "backups:backup/vzdump-lxc-100-2024_01_01-00_00_00.tar.zst"
+	Bytes        uint64       `json:"bytes"`
+	Scheduled    bool         `json:"scheduled"` // Taken by the instance's backup policy rather than on demand.
+	Created      int64        `json:"created"`   // Unix seconds
+}
+
+func newBackupFromStorage(backup *storage.Backup) Backup {
+	return Backup{
+		ID:           backup.ID,
+		InstanceID:   backup.InstanceID,
+		InstanceName: backup.InstanceName,
+		Kind:         InstanceType(backup.Kind),
+		Size:         InstanceSize(backup.Size),
+		Recurser:     backup.RecurserID,
+		Volume:       backup.Volume,
+		Bytes:        backup.Bytes,
+		Scheduled:    backup.Scheduled,
+		Created:      backup.Created,
+	}
+}
+
+func newBackupsFromStorage(backups []storage.Backup) []Backup {
+	returnedBackups := []Backup{}
+	for _, backup := range backups {
+		returnedBackups = append(returnedBackups, newBackupFromStorage(&backup))
+	}
+
+	return returnedBackups
+}
+
+// BackupPolicy is how often an instance is backed up and how many of those backups are kept.
+type BackupPolicy struct {
+	Schedule  BackupSchedule `json:"schedule"`
+	Retention int            `json:"retention"` // How many scheduled backups are kept; older ones are deleted.
+	LastRun   int64          `json:"last_run"`  // Unix seconds; 0 means the policy hasn't run yet.
+	NextRun   int64          `json:"next_run"`  // Unix seconds; 0 means the next time the scheduler checks.
+}
+
+func newBackupPolicyFromStorage(policy *storage.BackupPolicy) BackupPolicy {
+	return BackupPolicy{
+		Schedule:  BackupSchedule(policy.Schedule),
+		Retention: policy.Retention,
+		LastRun:   policy.LastRun,
+		NextRun:   nextBackupRun(BackupSchedule(policy.Schedule), policy.LastRun),
+	}
+}
+
+// Lists the backup archives of an instance on a backup storage, as seen from the given node.
+func (api *APIContext) listBackupArchives(
+	ctx context.Context, node, storageName string, id uint64,
+) ([]*proxmox.StorageContent, error) {
+	var archives []*proxmox.StorageContent
+	err := api.Client.Get(ctx, fmt.Sprintf("/nodes/%s/storage/%s/content?content=backup&vmid=%d", node,
+		storageName, id), &archives)
+	if err != nil {
+		return nil, err
+	}
+
+	return archives, nil
+}
+
+// A lock per instance, for work on an instance that mustn't overlap with itself. The zero value is ready to use.
+type instanceLocks struct {
+	mu    sync.Mutex
+	locks map[uint64]*instanceLock
+}
+
+type instanceLock struct {
+	sync.Mutex
+	holders int // How many callers hold or are waiting on the lock; it is dropped once nobody is.
+}
+
+// Blocks until the instance's lock is free, then takes it. Call the returned function to release it.
+func (l *instanceLocks) lock(id uint64) (unlock func()) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = map[uint64]*instanceLock{}
+	}
+
+	lock, ok := l.locks[id]
+	if !ok {
+		lock = &instanceLock{}
+		l.locks[id] = lock
+	}
+	lock.holders++
+	l.mu.Unlock()
+
+	lock.Lock()
+
+	return func() {
+		lock.Unlock()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		lock.holders--
+		if lock.holders == 0 {
+			delete(l.locks, id)
+		}
+	}
+}
+
+// Backs an instance up to the backup storage and records the backup against its owner. Proxmox doesn't say which
+// archive a backup wrote so it is found afterwards as the newest archive of the instance that isn't already recorded;
+// backups of the same instance are run one at a time so that archive can't belong to another backup still finishing.
+func (api *APIContext) backupInstance(
+	ctx context.Context, t *taskRun, resource *proxmox.ClusterResource, owner string, size InstanceSize,
+	scheduled bool,
+) (*storage.Backup, error) {
+	backups := api.BackupsConfig
+
+	unlock := api.backupLocks.lock(resource.VMID)
+	defer unlock()
+
+	var upid proxmox.UPID
+	err := api.Client.Post(ctx, fmt.Sprintf("/nodes/%s/vzdump", resource.Node), map[string]any{
+		"vmid":     resource.VMID,
+		"storage":  backups.Storage,
+		"mode":     backups.Mode,
+		"compress": backups.Compress,
+	}, &upid)
+	if err != nil {
+		return nil, fmt.Errorf("could not start backup: %w", err)
+	}
+
+	// vzdump keeps going even if we stop waiting, and an archive it finishes after that would never be recorded.
+	err = t.waitLong(ctx, upid)
+	if err != nil {
+		return nil, fmt.Errorf("backup did not complete: %w", err)
+	}
+
+	archives, err := api.listBackupArchives(ctx, resource.Node, backups.Storage, resource.VMID)
+	if err != nil {
+		return nil, fmt.Errorf("could not list backup archives: %w", err)
+	}
+
+	sort.Slice(archives, func(i, j int) bool {
+		return archives[i].Ctime > archives[j].Ctime
+	})
+
+	var archive *proxmox.StorageContent
+	for _, candidate := range archives {
+		recorded, err := api.DB.BackupVolumeRecorded(candidate.Volid)
+		if err != nil {
+			return nil, fmt.Errorf("could not check backup records: %w", err)
+		}
+
+		if !recorded {
+			archive = candidate
+			break
+		}
+	}
+
+	if archive == nil {
+		return nil, fmt.Errorf("backup finished but its archive could not be found on %s", backups.Storage)
+	}
+
+	backup := &storage.Backup{
+		InstanceID:   resource.VMID,
+		RecurserID:   owner,
+		InstanceName: resource.Name,
+		Kind:         string(instanceTypeFromResource(resource.Type)),
+		Size:         string(size),
+		Node:         resource.Node,
+		Storage:      backups.Storage,
+		Volume:       archive.Volid,
+		Bytes:        archive.Size,
+		Scheduled:    scheduled,
+		Created:      time.Now().Unix(),
+	}
+
+	err = api.DB.InsertBackup(backup)
+	if err != nil {
+		return nil, fmt.Errorf("could not record backup: %w", err)
+	}
+
+	t.logf("backed up instance %d to %s (%d bytes)", resource.VMID, archive.Volid, archive.Size)
+
+	return backup, nil
+}
+
+// Deletes a backup's archive from its storage and then its record. Archives that are already gone (ex. removed
+// directly in Proxmox) just have their record removed.
+func (api *APIContext) removeBackup(ctx context.Context, t *taskRun, backup storage.Backup) error {
+	archives, err := api.listBackupArchives(ctx, backup.Node, backup.Storage, backup.InstanceID)
+	if err != nil {
+		return fmt.Errorf("could not list backup archives: %w", err)
+	}
+
+	for _, archive := range archives {
+		if archive.Volid != backup.Volume {
+			continue
+		}
+
+		var upid proxmox.UPID
+		err := api.Client.Delete(ctx, fmt.Sprintf("/nodes/%s/storage/%s/content/%s", backup.Node, backup.Storage,
+			url.PathEscape(backup.Volume)), &upid)
+		if err != nil {
+			return fmt.Errorf("could not delete backup archive %s: %w", backup.Volume, err)
+		}
+
+		// Some storages delete archives straight away rather than in a task.
+		if upid != "" {
+			err = t.wait(ctx, upid)
+			if err != nil {
+				return fmt.Errorf("could not delete backup archive %s: %w", backup.Volume, err)
+			}
+		}
+
+		break
+	}
+
+	err = api.DB.DeleteBackup(backup.ID)
+	if err != nil && !errors.Is(err, storage.ErrEntityNotFound) {
+		return fmt.Errorf("backup archive was deleted but its record could not be removed: %w", err)
+	}
+
+	t.logf("deleted backup %d (%s)", backup.ID, backup.Volume)
+
+	return nil
+}
+
+// Deletes the scheduled backups of an instance beyond what its policy keeps.
+func (api *APIContext) pruneBackups(ctx context.Context, t *taskRun, record storage.Instance, retention int) error {
+	backups, err := api.DB.ListInstanceBackups(record.ID, record.Created)
+	if err != nil {
+		return fmt.Errorf("could not list backups: %w", err)
+	}
+
+	for _, backup := range backupsToPrune(backups, retention) {
+		err := api.removeBackup(ctx, t, backup)
+		if err != nil {
+			return fmt.Errorf("could not prune backup %d: %w", backup.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// backupScheduler takes the backups that instances' policies call for and prunes the ones they no longer keep.
+type backupScheduler struct {
+	api *APIContext
+}
+
+func newBackupScheduler(api *APIContext) *backupScheduler {
+	return &backupScheduler{
+		api: api,
+	}
+}
+
+// Policies that came due while the server was down are picked up by the first check, which runs straight away.
+func (s *backupScheduler) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.schedule(context.Background())
+		<-ticker.C
+	}
+}
+
+// Starts a backup of every instance whose policy is due. A policy isn't run again until its next backup is due even
+// if this one fails, so a broken instance isn't retried on every check.
+func (s *backupScheduler) schedule(ctx context.Context) {
+	now := time.Now()
+
+	policies, err := s.api.DB.ListBackupPolicies()
+	if err != nil {
+		log.Error().Err(err).Msg("could not list backup policies")
+		return
+	}
+
+	for _, policy := range policies {
+		if now.Unix() < nextBackupRun(BackupSchedule(policy.Schedule), policy.LastRun) {
+			continue
+		}
+
+		err := s.backup(ctx, policy, now)
+		if err != nil {
+			log.Error().Err(err).Uint64("instance_id", policy.InstanceID).Msg("could not run backup policy")
+		}
+	}
+}
+
+func (s *backupScheduler) backup(ctx context.Context, policy storage.BackupPolicy, now time.Time) error {
+	api := s.api
+
+	// Instances deleted outside of RC3 take their policy with them.
+	record, err := api.DB.GetInstance(policy.InstanceID)
+	if errors.Is(err, storage.ErrEntityNotFound) {
+		return api.DB.DeleteBackupPolicy(policy.InstanceID)
+	}
+	if err != nil {
+		return err
+	}
+
+	resource, err := api.findInstance(ctx, record.ID)
+	if errors.Is(err, errInstanceNotFound) {
+		return api.DB.DeleteBackupPolicy(policy.InstanceID)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = api.DB.UpdateBackupPolicyLastRun(policy.InstanceID, now.Unix())
+	if err != nil {
+		return err
+	}
+
+	quota, err := api.describeQuota(record.RecurserID)
+	if err != nil {
+		return err
+	}
+
+	err = checkBackupQuota(quota.Quota, quota.Usage)
+	if err != nil {
+		api.recordEvent(*record, EventKindBackupSkipped, now, fmt.Sprintf("the scheduled backup of instance %d was "+
+			"skipped: %v", record.ID, err))
+		return nil
+	}
+
+	_, err = api.startTask(AuthContext{RecurserID: record.RecurserID}, record.ID, "backup",
+		func(ctx context.Context, t *taskRun) error {
+			_, err := api.backupInstance(ctx, t, resource, record.RecurserID, InstanceSize(record.Size), true)
+			if err != nil {
+				api.recordEvent(*record, EventKindBackupFailed, time.Now(), fmt.Sprintf("the scheduled backup of "+
+					"instance %d failed: %v", record.ID, err))
+				return err
+			}
+
+			return api.pruneBackups(ctx, t, *record, policy.Retention)
+		})
+
+	return err
+}
+
+type GetBackupPolicyResponse struct {
+	Policy BackupPolicy `json:"policy"`
+}
+
+func (api *APIContext) getBackupPolicy(w http.ResponseWriter, r *http.Request) {
+	resource := api.resolveManagedInstance(context.Background(), w, r)
+	if resource == nil {
+		return
+	}
+
+	policy, err := api.DB.GetBackupPolicy(resource.VMID)
+	if err != nil {
+		if errors.Is(err, storage.ErrEntityNotFound) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("instance %d has no backup policy", resource.VMID))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get backup policy: %v", err))
+		return
+	}
+
+	writeResponse(w, http.StatusOK, GetBackupPolicyResponse{
+		Policy: newBackupPolicyFromStorage(policy),
+	})
+}
+
+type SetBackupPolicyRequest struct {
+	Schedule BackupSchedule `json:"schedule"`
+
+	// How many scheduled backups to keep. Defaults to 7, or the most allowed if that is fewer.
+	Retention int `json:"retention,omitempty"`
+}
+
+func (api *APIContext) setBackupPolicy(w http.ResponseWriter, r *http.Request) {
+	authCtx := CheckAuth(r)
+
+	if !api.requireBackups(w) {
+		return
+	}
+
+	var request SetBackupPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	if request.Schedule == "" {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("schedule is required; must be %q or %q",
+			BackupScheduleDaily, BackupScheduleWeekly))
+		return
+	}
+
+	maxRetention := api.BackupsConfig.MaxRetention
+	if request.Retention == 0 {
+		request.Retention = min(defaultBackupRetention, maxRetention)
+	}
+
+	if request.Retention < 1 || request.Retention > maxRetention {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("retention must be between 1 and %d", maxRetention))
+		return
+	}
+
+	resource := api.resolveManagedInstance(context.Background(), w, r)
+	if resource == nil {
+		return
+	}
+
+	// Policies follow RC3's record of the instance so they go away with it.
+	record, err := api.DB.GetInstance(resource.VMID)
+	if err != nil {
+		if errors.Is(err, storage.ErrEntityNotFound) {
+			writeError(w, http.StatusConflict, fmt.Sprintf("instance %d wasn't created by RC3 so it can't have a "+
+				"backup policy; take backups of it on demand instead", resource.VMID))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get instance record: %v", err))
+		return
+	}
+
+	err = api.DB.PutBackupPolicy(&storage.BackupPolicy{
+		InstanceID: record.ID,
+		RecurserID: record.RecurserID,
+		Schedule:   string(request.Schedule),
+		Retention:  request.Retention,
+		Created:    time.Now().Unix(),
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not save backup policy: %v", err))
+		return
+	}
+
+	policy, err := api.DB.GetBackupPolicy(record.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get backup policy: %v", err))
+		return
+	}
+
+	log.Info().Uint64("id", record.ID).Str("schedule", policy.Schedule).Int("retention", policy.Retention).
+		Str("recurser_id", authCtx.RecurserID).Msg("backup policy set")
+
+	writeResponse(w, http.StatusOK, GetBackupPolicyResponse{
+		Policy: newBackupPolicyFromStorage(policy),
+	})
+}
+
+// Stops scheduled backups of an instance. Backups that were already taken are kept.
+func (api *APIContext) deleteBackupPolicy(w http.ResponseWriter, r *http.Request) {
+	authCtx := CheckAuth(r)
+
+	resource := api.resolveManagedInstance(context.Background(), w, r)
+	if resource == nil {
+		return
+	}
+
+	err := api.DB.DeleteBackupPolicy(resource.VMID)
+	if err != nil {
+		if errors.Is(err, storage.ErrEntityNotFound) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("instance %d has no backup policy", resource.VMID))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not delete backup policy: %v", err))
+		return
+	}
+
+	log.Info().Uint64("id", resource.VMID).Str("recurser_id", authCtx.RecurserID).Msg("backup policy removed")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type ListBackupsResponse struct {
+	Backups []Backup `json:"backups"` // Newest first.
+}
+
+// Lists the backups of a single instance. Backups of earlier instances that had the same ID are left out.
+func (api *APIContext) listInstanceBackups(w http.ResponseWriter, r *http.Request) {
+	resource := api.resolveManagedInstance(context.Background(), w, r)
+	if resource == nil {
+		return
+	}
+
+	var since int64
+	record, err := api.DB.GetInstance(resource.VMID)
+	if err != nil && !errors.Is(err, storage.ErrEntityNotFound) {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get instance record: %v", err))
+		return
+	}
+	if record != nil {
+		since = record.Created
+	}
+
+	backups, err := api.DB.ListInstanceBackups(resource.VMID, since)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not list backups: %v", err))
+		return
+	}
+
+	writeResponse(w, http.StatusOK, ListBackupsResponse{
+		Backups: newBackupsFromStorage(backups),
+	})
+}
+
+// Lists every backup that counts against the caller, including backups of instances that have since been deleted.
+func (api *APIContext) listMyBackups(w http.ResponseWriter, r *http.Request) {
+	authCtx := CheckAuth(r)
+
+	backups, err := api.DB.ListRecurserBackups(authCtx.RecurserID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not list backups: %v", err))
+		return
+	}
+
+	writeResponse(w, http.StatusOK, ListBackupsResponse{
+		Backups: newBackupsFromStorage(backups),
+	})
+}
+
+type BackupTaskResponse struct {
+	Task Task `json:"task"`
+}
+
+// Backs an instance up on demand. Backups taken this way are never pruned by the instance's policy.
+func (api *APIContext) createBackup(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	authCtx := CheckAuth(r)
+
+	if !api.requireBackups(w) {
+		return
+	}
+
+	resource := api.resolveManagedInstance(ctx, w, r)
+	if resource == nil {
+		return
+	}
+
+	record, err := api.DB.GetInstance(resource.VMID)
+	if err != nil && !errors.Is(err, storage.ErrEntityNotFound) {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get instance record: %v", err))
+		return
+	}
+
+	if record == nil {
+		record = &storage.Instance{}
+	}
+
+	// Instances RC3 knows nothing about can only be managed by admins, who take on their backups.
+	size, owner := instanceMetadata(resource.Tags, *record)
+	if owner == "" {
+		owner = authCtx.RecurserID
+	}
+
+	quota, err := api.describeQuota(owner)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	err = checkBackupQuota(quota.Quota, quota.Usage)
+	if err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	log.Info().Uint64("id", resource.VMID).Str("recurser_id", authCtx.RecurserID).Msg("backing up instance")
+
+	task, err := api.startTask(authCtx, resource.VMID, "backup", func(ctx context.Context, t *taskRun) error {
+		_, err := api.backupInstance(ctx, t, resource, owner, size, false)
+		return err
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not start backup: %v", err))
+		return
+	}
+
+	api.writeTaskResponse(w, r, task, http.StatusCreated, func(task Task) any {
+		return BackupTaskResponse{Task: task}
+	})
+}
+
+// Finds the backup named in the URL and makes sure the caller is allowed to use it. If anything goes wrong the
+// appropriate error is written and nil is returned.
+func (api *APIContext) resolveBackup(w http.ResponseWriter, r *http.Request) *storage.Backup {
+	authCtx := CheckAuth(r)
+
+	idStr := chi.URLParam(r, "backup_id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid backup id %q; must be a number", idStr))
+		return nil
+	}
+
+	backup, err := api.DB.GetBackup(id)
+	if err != nil {
+		if errors.Is(err, storage.ErrEntityNotFound) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("backup %d not found", id))
+			return nil
+		}
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get backup %d: %v", id, err))
+		return nil
+	}
+
+	if !authCtx.IsAdmin && backup.RecurserID != authCtx.RecurserID {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("backup %d does not belong to you", id))
+		return nil
+	}
+
+	return backup
+}
+
+type GetBackupResponse struct {
+	Backup Backup `json:"backup"`
+}
+
+func (api *APIContext) getBackup(w http.ResponseWriter, r *http.Request) {
+	backup := api.resolveBackup(w, r)
+	if backup == nil {
+		return
+	}
+
+	writeResponse(w, http.StatusOK, GetBackupResponse{
+		Backup: newBackupFromStorage(backup),
+	})
+}
+
+func (api *APIContext) deleteBackup(w http.ResponseWriter, r *http.Request) {
+	authCtx := CheckAuth(r)
+
+	backup := api.resolveBackup(w, r)
+	if backup == nil {
+		return
+	}
+
+	log.Info().Int64("backup_id", backup.ID).Str("volume", backup.Volume).Str("recurser_id", authCtx.RecurserID).
+		Msg("deleting backup")
+
+	task, err := api.startTask(authCtx, backup.InstanceID, "delete-backup",
+		func(ctx context.Context, t *taskRun) error {
+			return api.removeBackup(ctx, t, *backup)
+		})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not start deleting backup: %v", err))
+		return
+	}
+
+	api.writeTaskResponse(w, r, task, http.StatusOK, func(task Task) any {
+		return BackupTaskResponse{Task: task}
+	})
+}
+
+type RestoreBackupRequest struct {
+	// Overwrite the instance the backup was taken of instead of restoring into a new instance. Everything written
+	// to the instance since the backup, including its snapshots, is lost.
+	InPlace bool `json:"in_place,omitempty"`
+
+	// The name of the new instance; a name is generated if left out. Not used when restoring in place.
+	Name string `json:"name,omitempty"`
+
+	// How long the new instance should live (ex. "72h"). Not used when restoring in place.
+	ExpiresIn string `json:"expires_in,omitempty"`
+}
+
+type RestoreBackupResponse struct {
+	InstanceID uint64 `json:"instance_id"`
+	Task       Task   `json:"task"`
+}
+
+// The parameters that restore a backup into the instance with the given ID. Restored instances keep the settings
+// they were backed up with but their disks go on the instance storage.
+func (api *APIContext) restoreParams(backup *storage.Backup, id uint64) map[string]any {
+	params := map[string]any{
+		"vmid": id,
+	}
+
+	if InstanceType(backup.Kind) == InstanceTypeVM {
+		params["archive"] = backup.Volume
+	} else {
+		params["ostemplate"] = backup.Volume
+		params["restore"] = 1
+	}
+
+	if api.ProxmoxConfig.InstanceStorage != "" {
+		params["storage"] = api.ProxmoxConfig.InstanceStorage
+	}
+
+	return params
+}
+
+// Restores a backup either over the instance it was taken of or into a brand new instance.
+func (api *APIContext) restoreBackup(w http.ResponseWriter, r *http.Request) {
+	var request RestoreBackupRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	backup := api.resolveBackup(w, r)
+	if backup == nil {
+		return
+	}
+
+	if request.InPlace {
+		api.restoreInPlace(w, r, backup)
+		return
+	}
+
+	api.restoreAsNewInstance(w, r, backup, request)
+}
+
+// Restores a backup over the instance it was taken of. Proxmox can only restore over stopped instances so running
+// instances are stopped first and started again afterwards.
+func (api *APIContext) restoreInPlace(w http.ResponseWriter, r *http.Request, backup *storage.Backup) {
+	ctx := context.Background()
+	authCtx := CheckAuth(r)
+
+	resource, err := api.findInstance(ctx, backup.InstanceID)
+	if err != nil {
+		if errors.Is(err, errInstanceNotFound) {
+			writeError(w, http.StatusConflict, fmt.Sprintf("instance %d no longer exists; restore the backup into "+
+				"a new instance instead", backup.InstanceID))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not look up instance %d: %v",
+			backup.InstanceID, err))
+		return
+	}
+
+	allowed, err := api.canManageInstance(authCtx, resource)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError,
+			fmt.Sprintf("could not check ownership of instance %d: %v", resource.VMID, err))
+		return
+	}
+
+	if !allowed {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("instance %d does not belong to you", resource.VMID))
+		return
+	}
+
+	// Proxmox hands out the IDs of deleted instances again so the instance with this ID may not be the one that was
+	// backed up.
+	record, err := api.DB.GetInstance(resource.VMID)
+	if err != nil && !errors.Is(err, storage.ErrEntityNotFound) {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get instance record: %v", err))
+		return
+	}
+
+	if (record != nil && record.Created > backup.Created) ||
+		resource.Type != InstanceType(backup.Kind).resourceType() {
+		writeError(w, http.StatusConflict, fmt.Sprintf("instance %d isn't the instance backup %d was taken of; "+
+			"restore the backup into a new instance instead", resource.VMID, backup.ID))
+		return
+	}
+
+	wasRunning := resource.Status == "running"
+
+	log.Info().Uint64("id", resource.VMID).Int64("backup_id", backup.ID).Str("recurser_id", authCtx.RecurserID).
+		Msg("restoring instance from backup")
+
+	task, err := api.startTask(authCtx, resource.VMID, "restore", func(ctx context.Context, t *taskRun) error {
+		unlock := api.backupLocks.lock(resource.VMID)
+		defer unlock()
+
+		if wasRunning {
+			t.logf("stopping instance %d before restoring", resource.VMID)
+
+			upid, err := api.performPowerAction(ctx, resource, PowerActionStop, PowerActionRequest{})
+			if err != nil {
+				return fmt.Errorf("could not stop instance: %w", err)
+			}
+
+			err = t.wait(ctx, upid)
+			if err != nil {
+				return fmt.Errorf("could not stop instance: %w", err)
+			}
+		}
+
+		params := api.restoreParams(backup, resource.VMID)
+		params["force"] = 1
+
+		var upid proxmox.UPID
+		err := api.Client.Post(ctx, fmt.Sprintf("/nodes/%s/%s", resource.Node, resource.Type), params, &upid)
+		if err != nil {
+			return fmt.Errorf("could not restore backup %d: %w", backup.ID, err)
+		}
+
+		err = t.waitLong(ctx, upid)
+		if err != nil {
+			return fmt.Errorf("could not restore backup %d: %w", backup.ID, err)
+		}
+
+		api.addresses.forget(resource.VMID)
+
+		// Restoring replaces the instance's disks and its snapshots go with them.
+		err = api.DB.DeleteInstanceSnapshots(resource.VMID)
+		if err != nil {
+			log.Error().Err(err).Uint64("id", resource.VMID).
+				Msg("could not remove snapshot records of restored instance")
+		}
+
+		if !wasRunning {
+			return nil
+		}
+
+		t.logf("starting instance %d again", resource.VMID)
+
+		upid, err = api.performPowerAction(ctx, resource, PowerActionStart, PowerActionRequest{})
+		if err != nil {
+			return fmt.Errorf("restored but could not start instance: %w", err)
+		}
+
+		return t.wait(ctx, upid)
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not track restore: %v", err))
+		return
+	}
+
+	api.writeTaskResponse(w, r, task, http.StatusOK, func(task Task) any {
+		return RestoreBackupResponse{InstanceID: resource.VMID, Task: task}
+	})
+}
+
+// Restores a backup into a brand new instance owned by the caller. The new instance counts against the caller's
+// quota like any other and is created on the node the backup was taken on, since backup storage isn't always shared.
+func (api *APIContext) restoreAsNewInstance(w http.ResponseWriter, r *http.Request, backup *storage.Backup,
+	request RestoreBackupRequest,
+) {
+	ctx := context.Background()
+	authCtx := CheckAuth(r)
+
+	kind := InstanceType(backup.Kind)
+
+	size, ok := api.findSize(backup.Size)
+	if !ok {
+		writeError(w, http.StatusConflict, fmt.Sprintf("backup %d is of a %q instance, which is no longer offered, "+
+			"so it can't be counted against your quota", backup.ID, backup.Size))
+		return
+	}
+
+	expires, err := newInstanceExpiry(api.ExpiryConfig, request.ExpiresIn, time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	name, ok := api.chooseInstanceName(ctx, w, request.Name)
+	if !ok {
+		return
+	}
+
+	createRequest := CreateInstanceRequest{
+		Name:         name,
+		InstanceType: kind,
+		Size:         InstanceSize(backup.Size),
+		ExpiresIn:    request.ExpiresIn,
+	}
+
+	nextID, ok := api.reserveInstance(ctx, w, authCtx, backup.Node, backup.Volume, size, expires, createRequest)
+	if !ok {
+		return
+	}
+
+	tags := strings.Join([]string{
+		encodeTag(tagKeySize, backup.Size),
+		encodeTag(tagKeyRecurser, authCtx.RecurserID),
+	}, ";")
+
+	// Regenerating unique settings (ex. MAC addresses) stops the new instance clashing with the original.
+	params := api.restoreParams(backup, uint64(nextID))
+	params["unique"] = 1
+	if kind == InstanceTypeContainer {
+		params["hostname"] = name
+		params["tags"] = tags
+	}
+
+	var upid proxmox.UPID
+	err = api.Client.Post(ctx, fmt.Sprintf("/nodes/%s/%s", backup.Node, kind.resourceType()), params, &upid)
+	if err != nil {
+		api.forgetFailedInstance(ctx, uint64(nextID))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not restore backup %d: %v", backup.ID, err))
+		return
+	}
+
+	log.Info().Int("id", nextID).Int64("backup_id", backup.ID).Str("recurser_id", authCtx.RecurserID).
+		Msg("restoring backup into new instance")
+
+	task, err := api.startTask(authCtx, uint64(nextID), "restore", func(ctx context.Context, t *taskRun) error {
+		err := api.finishRestore(ctx, t, upid, backup.Node, kind, nextID, name, tags)
+		if err != nil {
+			api.cleanUpFailedInstance(ctx, t, uint64(nextID), err)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not track restore: %v", err))
+		return
+	}
+
+	api.writeCreatedInstance(w, r, task, Instance{
+		ID:       uint64(nextID),
+		Kind:     kind,
+		Size:     InstanceSize(backup.Size),
+		Name:     name,
+		Node:     backup.Node,
+		Status:   instanceStatusCreating,
+		Recurser: authCtx.RecurserID,
+	})
+}
+
+// Waits for a backup to be restored into a new instance and then starts it. VMs can't be renamed or retagged while
+// being restored so that is done once the restore finishes.
+func (api *APIContext) finishRestore(ctx context.Context, t *taskRun, restoreUPID proxmox.UPID, node string,
+	kind InstanceType, id int, name, tags string,
+) error {
+	err := t.waitLong(ctx, restoreUPID)
+	if err != nil {
+		return fmt.Errorf("could not restore backup: %w", err)
+	}
+
+	path := fmt.Sprintf("/nodes/%s/%s/%d", node, kind.resourceType(), id)
+
+	var upid proxmox.UPID
+	if kind == InstanceTypeVM {
+		err = api.Client.Post(ctx, path+"/config", map[string]any{
+			"name": name,
+			"tags": tags,
+		}, &upid)
+		if err != nil {
+			return fmt.Errorf("could not configure restored vm: %w", err)
+		}
+
+		err = t.wait(ctx, upid)
+		if err != nil {
+			return fmt.Errorf("could not configure restored vm: %w", err)
+		}
+	}
+
+	upid = ""
+	err = api.Client.Post(ctx, path+"/status/start", nil, &upid)
+	if err != nil {
+		return fmt.Errorf("could not start restored instance: %w", err)
+	}
+
+	return t.wait(ctx, upid)
+}
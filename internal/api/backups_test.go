@@ -0,0 +1,70 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/clintjedwards/rc3/internal/storage"
+)
+
+func TestNextBackupRun(t *testing.T) {
+	lastRun := testNow.Unix()
+
+	tests := map[string]struct {
+		schedule BackupSchedule
+		lastRun  int64
+		want     int64
+	}{
+		"daily never run":  {schedule: BackupScheduleDaily, want: 0},
+		"weekly never run": {schedule: BackupScheduleWeekly, want: 0},
+		"daily":            {schedule: BackupScheduleDaily, lastRun: lastRun, want: lastRun + 24*60*60},
+		"weekly":           {schedule: BackupScheduleWeekly, lastRun: lastRun, want: lastRun + 7*24*60*60},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := nextBackupRun(test.schedule, test.lastRun)
+			if got != test.want {
+				t.Errorf("got next run %d; want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBackupsToPrune(t *testing.T) {
+	// Newest first, as they come out of storage.
+	backups := []storage.Backup{
+		{ID: 6, Scheduled: true},
+		{ID: 5},
+		{ID: 4, Scheduled: true},
+		{ID: 3, Scheduled: true},
+		{ID: 2},
+		{ID: 1, Scheduled: true},
+	}
+
+	tests := map[string]struct {
+		backups   []storage.Backup
+		retention int
+		want      []int64
+	}{
+		"none":                       {backups: []storage.Backup{}, retention: 2, want: []int64{}},
+		"under retention":            {backups: backups, retention: 5, want: []int64{}},
+		"at retention":               {backups: backups, retention: 4, want: []int64{}},
+		"over retention":             {backups: backups, retention: 2, want: []int64{3, 1}},
+		"keeps the newest only":      {backups: backups, retention: 1, want: []int64{4, 3, 1}},
+		"on demand backups are kept": {backups: backups[1:2], retention: 0, want: []int64{}},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := []int64{}
+			for _, backup := range backupsToPrune(test.backups, test.retention) {
+				got = append(got, backup.ID)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got backups %v to prune; want %v", got, test.want)
+			}
+		})
+	}
+}
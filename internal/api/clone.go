@@ -0,0 +1,225 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clintjedwards/rc3/internal/placement"
+	"github.com/clintjedwards/rc3/internal/storage"
+	"github.com/luthermonson/go-proxmox"
+	"github.com/rs/zerolog/log"
+)
+
+type CloneInstanceRequest struct {
+	// The name of the new instance; a name is generated if left out.
+	Name string `json:"name,omitempty"`
+
+	// Make a linked clone that shares unchanged disk blocks with the source instead of copying them. Proxmox can only
+	// link clones to templates on storage that supports it, and linked clones stay on the same node as their source.
+	Linked bool `json:"linked,omitempty"`
+
+	// How long the new instance should live (ex. "72h"). Defaults to how long new instances usually live.
+	ExpiresIn string `json:"expires_in,omitempty"`
+}
+
+// Works out the settings to record for a clone. Clones keep the image of the instance they were cloned from so RC3
+// still knows which user to log in as.
+func cloneSettings(
+	source storage.Instance, kind InstanceType, size InstanceSize, name, expiresIn string,
+) CreateInstanceRequest {
+	var settings CreateInstanceRequest
+	if source.Settings != "" {
+		err := json.Unmarshal([]byte(source.Settings), &settings)
+		if err != nil {
+			log.Debug().Err(err).Uint64("id", source.ID).Msg("could not decode instance settings")
+		}
+	}
+
+	return CreateInstanceRequest{
+		Name:         name,
+		InstanceType: kind,
+		Size:         size,
+		Image:        settings.Image,
+		ExpiresIn:    expiresIn,
+	}
+}
+
+// Copies an instance into a new one owned by the caller. The copy is placed like any new instance (unless it is a
+// linked clone), counts against the caller's quota and is started if the source was running.
+func (api *APIContext) cloneInstance(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	authCtx := CheckAuth(r)
+
+	var request CloneInstanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	resource := api.resolveManagedInstance(ctx, w, r)
+	if resource == nil {
+		return
+	}
+
+	if request.Linked && resource.Template != 1 {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("instance %d isn't a template and Proxmox can only make "+
+			"linked clones of templates; leave out linked to make a full clone", resource.VMID))
+		return
+	}
+
+	record, err := api.DB.GetInstance(resource.VMID)
+	if err != nil && !errors.Is(err, storage.ErrEntityNotFound) {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get instance record: %v", err))
+		return
+	}
+
+	if record == nil {
+		record = &storage.Instance{}
+	}
+
+	kind := instanceTypeFromResource(resource.Type)
+	sizeName, _ := instanceMetadata(resource.Tags, *record)
+
+	size, ok := api.findSize(string(sizeName))
+	if !ok {
+		writeError(w, http.StatusConflict, fmt.Sprintf("instance %d isn't a size RC3 offers (%q) so its clone can't "+
+			"be counted against your quota", resource.VMID, sizeName))
+		return
+	}
+
+	expires, err := newInstanceExpiry(api.ExpiryConfig, request.ExpiresIn, time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	name, ok := api.chooseInstanceName(ctx, w, request.Name)
+	if !ok {
+		return
+	}
+
+	var targetNodeName string
+	if request.Linked {
+		targetNodeName, err = api.placeInstanceOn(ctx, size, resource.Node)
+	} else {
+		targetNodeName, err = api.placeInstance(ctx, size)
+	}
+	if err != nil {
+		if errors.Is(err, placement.ErrNoCapacity) {
+			writeError(w, http.StatusServiceUnavailable, fmt.Sprintf("could not find a node for the clone: %v", err))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not choose a node for the clone: %v", err))
+		return
+	}
+
+	settings := cloneSettings(*record, kind, InstanceSize(size.Name), name, request.ExpiresIn)
+
+	nextID, ok := api.reserveInstance(ctx, w, authCtx, targetNodeName, strconv.FormatUint(resource.VMID, 10), size,
+		expires, settings)
+	if !ok {
+		return
+	}
+
+	params := map[string]any{
+		"newid": nextID,
+	}
+
+	if kind == InstanceTypeVM {
+		params["name"] = name
+	} else {
+		params["hostname"] = name
+	}
+
+	// Proxmox refuses a target storage for linked clones since their disks have to stay next to the template's.
+	if !request.Linked {
+		params["full"] = 1
+		if api.ProxmoxConfig.InstanceStorage != "" {
+			params["storage"] = api.ProxmoxConfig.InstanceStorage
+		}
+	}
+
+	if targetNodeName != resource.Node {
+		params["target"] = targetNodeName
+	}
+
+	var cloneUPID proxmox.UPID
+	err = api.Client.Post(ctx, instancePath(resource)+"/clone", params, &cloneUPID)
+	if err != nil {
+		api.forgetFailedInstance(ctx, uint64(nextID))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not clone instance %d: %v",
+			resource.VMID, err))
+		return
+	}
+
+	log.Info().Uint64("source_id", resource.VMID).Int("id", nextID).Str("node", targetNodeName).
+		Bool("linked", request.Linked).Str("recurser_id", authCtx.RecurserID).Msg("cloning instance")
+
+	start := resource.Status == "running"
+
+	// Copying full disks can take minutes so the rest happens in the background.
+	task, err := api.startTask(authCtx, uint64(nextID), "clone", func(ctx context.Context, t *taskRun) error {
+		err := api.finishClone(ctx, t, cloneUPID, targetNodeName, kind, nextID, size.Name, authCtx.RecurserID, start)
+		if err != nil {
+			api.cleanUpFailedInstance(ctx, t, uint64(nextID), err)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not track clone: %v", err))
+		return
+	}
+
+	api.writeCreatedInstance(w, r, task, Instance{
+		ID:       uint64(nextID),
+		Kind:     kind,
+		Size:     InstanceSize(size.Name),
+		Name:     name,
+		Node:     targetNodeName,
+		Status:   instanceStatusCreating,
+		Recurser: authCtx.RecurserID,
+	})
+}
+
+// Waits for a clone to finish, retags it for its new owner and starts it if the source was running. Clones copy their
+// source's tags, which would otherwise say the clone belongs to whoever owns the source.
+func (api *APIContext) finishClone(ctx context.Context, t *taskRun, cloneUPID proxmox.UPID, node string,
+	kind InstanceType, id int, size, owner string, start bool,
+) error {
+	err := t.waitLong(ctx, cloneUPID)
+	if err != nil {
+		return fmt.Errorf("clone did not complete: %w", err)
+	}
+
+	path := fmt.Sprintf("/nodes/%s/%s/%d", node, kind.resourceType(), id)
+
+	err = api.Client.Put(ctx, path+"/config", map[string]any{
+		"tags": strings.Join([]string{
+			encodeTag(tagKeySize, size),
+			encodeTag(tagKeyRecurser, owner),
+		}, ";"),
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("could not tag clone: %w", err)
+	}
+
+	if !start {
+		return nil
+	}
+
+	var upid proxmox.UPID
+	err = api.Client.Post(ctx, path+"/status/start", nil, &upid)
+	if err != nil {
+		return fmt.Errorf("could not start clone: %w", err)
+	}
+
+	return t.wait(ctx, upid)
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/luthermonson/go-proxmox"
+	"github.com/rs/zerolog/log"
+)
+
+// How long to wait for Proxmox to accept the console websocket. Tickets from termproxy are only good for a few
+// seconds so there's no point waiting much longer.
+const consoleHandshakeTimeout = 10 * time.Second
+
+// The default upgrader only accepts websockets opened by pages on the same host, which stops other sites from using
+// a recurser's session cookie to open consoles on their behalf.
+var consoleUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+// Works out the websocket URL for a path on the Proxmox API, ex. "https://pve:8006/api2/json" becomes
+// "wss://pve:8006/api2/json/<path>".
+func proxmoxWebsocketURL(apiURL, path string) (string, error) {
+	parsed, err := url.Parse(strings.TrimSuffix(apiURL, "/") + path)
+	if err != nil {
+		return "", err
+	}
+
+	switch parsed.Scheme {
+	case "https":
+		parsed.Scheme = "wss"
+	case "http":
+		parsed.Scheme = "ws"
+	default:
+		return "", fmt.Errorf("unsupported proxmox url scheme %q", parsed.Scheme)
+	}
+
+	return parsed.String(), nil
+}
+
+// Opens a terminal session on an instance through Proxmox's termproxy and logs in to it with the ticket, so the
+// recurser on the other end never needs Proxmox credentials of their own. VMs only have a terminal if they have a
+// serial port.
+func (api *APIContext) dialConsole(ctx context.Context, resource *proxmox.ClusterResource) (*websocket.Conn, error) {
+	var term proxmox.Term
+	err := api.Client.Post(ctx, instancePath(resource)+"/termproxy", nil, &term)
+	if err != nil {
+		return nil, fmt.Errorf("could not open terminal: %w", err)
+	}
+
+	path := fmt.Sprintf("%s/vncwebsocket?port=%d&vncticket=%s", instancePath(resource), term.Port,
+		url.QueryEscape(term.Ticket))
+
+	consoleURL, err := proxmoxWebsocketURL(api.ProxmoxConfig.URL, path)
+	if err != nil {
+		return nil, fmt.Errorf("could not work out console url: %w", err)
+	}
+
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: consoleHandshakeTimeout,
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: !api.ProxmoxConfig.UseTLS,
+		},
+	}
+
+	headers := http.Header{}
+	headers.Set("Authorization", fmt.Sprintf("PVEAPIToken=%s=%s", api.ProxmoxConfig.TokenID,
+		api.ProxmoxConfig.TokenSecret))
+
+	conn, _, err := dialer.DialContext(ctx, consoleURL, headers)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to console: %w", err)
+	}
+
+	// Termproxy won't send anything until the session is logged in with the ticket it handed out.
+	err = conn.WriteMessage(websocket.BinaryMessage, []byte(term.User+":"+term.Ticket+"\n"))
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("could not log in to console: %w", err)
+	}
+
+	_, reply, err := conn.ReadMessage()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("could not log in to console: %w", err)
+	}
+
+	if string(reply) != "OK" {
+		conn.Close()
+		return nil, fmt.Errorf("console refused login: %q", reply)
+	}
+
+	return conn, nil
+}
+
+// Closes a websocket with a reason the other side can show. Control frames are limited to 125 bytes so long reasons
+// are cut short.
+func closeWebsocket(conn *websocket.Conn, code int, reason string) {
+	const maxReasonLength = 123 // 125 bytes, less the two used by the close code.
+	if len(reason) > maxReasonLength {
+		reason = reason[:maxReasonLength]
+	}
+
+	err := conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(time.Second))
+	if err != nil {
+		log.Debug().Err(err).Msg("could not close websocket")
+	}
+}
+
+// Copies messages from one websocket to another until either side closes.
+func pipeWebsocket(dst, src *websocket.Conn, done chan<- error) {
+	for {
+		messageType, message, err := src.ReadMessage()
+		if err != nil {
+			done <- err
+			return
+		}
+
+		err = dst.WriteMessage(messageType, message)
+		if err != nil {
+			done <- err
+			return
+		}
+	}
+}
+
+// Upgrades the request to a websocket connected to the instance's terminal. Messages are passed through untouched
+// so the browser speaks Proxmox's termproxy protocol ("0:<length>:<data>" for input, "1:<cols>:<rows>:" to resize
+// and "2" to keep the session alive); RC3 only handles logging in to the session.
+func (api *APIContext) openConsole(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	authCtx := CheckAuth(r)
+
+	if !websocket.IsWebSocketUpgrade(r) {
+		writeError(w, http.StatusBadRequest, "the console is a websocket; open /console/<id> in a browser to use it")
+		return
+	}
+
+	resource := api.resolveManagedInstance(ctx, w, r)
+	if resource == nil {
+		return
+	}
+
+	if resource.Status != "running" {
+		writeError(w, http.StatusConflict, fmt.Sprintf("instance %d is %s; start it to use its console",
+			resource.VMID, resource.Status))
+		return
+	}
+
+	// Upgrading before asking Proxmox for a terminal means handshakes the upgrader rejects (ex. from another site)
+	// never open one. The upgrader writes its own error response when it does.
+	browserConn, err := consoleUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Debug().Err(err).Uint64("id", resource.VMID).Msg("could not upgrade console request")
+		return
+	}
+	defer browserConn.Close()
+
+	instanceConn, err := api.dialConsole(ctx, resource)
+	if err != nil {
+		log.Error().Err(err).Uint64("id", resource.VMID).Msg("could not open console")
+		closeWebsocket(browserConn, websocket.CloseInternalServerErr, err.Error())
+		return
+	}
+	defer instanceConn.Close()
+
+	log.Info().Uint64("id", resource.VMID).Str("recurser_id", authCtx.RecurserID).Msg("console opened")
+
+	done := make(chan error, 2)
+	go pipeWebsocket(instanceConn, browserConn, done)
+	go pipeWebsocket(browserConn, instanceConn, done)
+
+	// Whichever side hangs up first ends the session; the deferred closes unblock the other direction.
+	err = <-done
+
+	log.Info().Err(err).Uint64("id", resource.VMID).Str("recurser_id", authCtx.RecurserID).Msg("console closed")
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/clintjedwards/rc3/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+// The most events returned when listing a recurser's events.
+const maxRecurserEvents = 100
+
+type EventKind string
+
+const (
+	EventKindExpiryWarning EventKind = "expiry_warning"
+	EventKindExpired       EventKind = "expired"
+	EventKindReaped        EventKind = "reaped"
+	EventKindExtended      EventKind = "extended"
+	EventKindIdleShutdown  EventKind = "idle_shutdown"
+	EventKindPinned        EventKind = "pinned"
+	EventKindUnpinned      EventKind = "unpinned"
+	EventKindBackupFailed  EventKind = "backup_failed"
+	EventKindBackupSkipped EventKind = "backup_skipped"
+)
+
+// Event is something that happened to an instance that its owner should know about, usually something RC3 did to
+// it on its own (ex. stopping it because it expired).
+type Event struct {
+	ID         int64     `json:"id"`
+	InstanceID uint64    `json:"instance_id"`
+	Kind       EventKind `json:"kind"`
+	Message    string    `json:"message"`
+	Created    int64     `json:"created"` // Unix seconds
+}
+
+func newEventFromStorage(event *storage.Event) Event {
+	return Event{
+		ID:         event.ID,
+		InstanceID: event.InstanceID,
+		Kind:       EventKind(event.Kind),
+		Message:    event.Message,
+		Created:    event.Created,
+	}
+}
+
+func newEventsFromStorage(events []storage.Event) []Event {
+	returnedEvents := []Event{}
+	for _, event := range events {
+		returnedEvents = append(returnedEvents, newEventFromStorage(&event))
+	}
+
+	return returnedEvents
+}
+
+// Records an event for an instance's owner. Events are informational so failing to record one is logged rather than
+// stopping whatever caused it.
+func (api *APIContext) recordEvent(instance storage.Instance, kind EventKind, now time.Time, message string) {
+	log.Info().Uint64("instance_id", instance.ID).Str("recurser_id", instance.RecurserID).Str("kind", string(kind)).
+		Msg(message)
+
+	err := api.DB.InsertEvent(&storage.Event{
+		InstanceID: instance.ID,
+		RecurserID: instance.RecurserID,
+		Kind:       string(kind),
+		Message:    message,
+		Created:    now.Unix(),
+	})
+	if err != nil {
+		log.Error().Err(err).Uint64("instance_id", instance.ID).Msg("could not record event")
+	}
+}
+
+type ListEventsResponse struct {
+	Events []Event `json:"events"` // Newest first.
+}
+
+// Lists the events for a single instance. Anyone who can manage the instance can see its events.
+func (api *APIContext) listInstanceEvents(w http.ResponseWriter, r *http.Request) {
+	resource := api.resolveManagedInstance(context.Background(), w, r)
+	if resource == nil {
+		return
+	}
+
+	// Events from before the instance was created belong to an earlier instance with the same ID.
+	var since int64
+	record, err := api.DB.GetInstance(resource.VMID)
+	if err != nil && !errors.Is(err, storage.ErrEntityNotFound) {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get instance record: %v", err))
+		return
+	}
+	if record != nil {
+		since = record.Created
+	}
+
+	events, err := api.DB.ListInstanceEvents(resource.VMID, since)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not list events: %v", err))
+		return
+	}
+
+	writeResponse(w, http.StatusOK, ListEventsResponse{
+		Events: newEventsFromStorage(events),
+	})
+}
+
+// Lists the most recent events for all of the caller's instances, including ones that have since been deleted.
+func (api *APIContext) listMyEvents(w http.ResponseWriter, r *http.Request) {
+	authCtx := CheckAuth(r)
+
+	events, err := api.DB.ListRecurserEvents(authCtx.RecurserID, maxRecurserEvents)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not list events: %v", err))
+		return
+	}
+
+	writeResponse(w, http.StatusOK, ListEventsResponse{
+		Events: newEventsFromStorage(events),
+	})
+}
@@ -0,0 +1,380 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/clintjedwards/rc3/internal/conf"
+	"github.com/clintjedwards/rc3/internal/storage"
+	"github.com/luthermonson/go-proxmox"
+	"github.com/rs/zerolog/log"
+)
+
+// How far the reaper has got with an instance that is close to expiring.
+const (
+	expiryStageNone     = ""
+	expiryStageWarned   = "warned"   // The owner has been told the instance is about to expire.
+	expiryStageStopped  = "stopped"  // The instance expired and was stopped.
+	expiryStageDeleting = "deleting" // The instance is being deleted.
+)
+
+type expiryAction int
+
+const (
+	expiryActionNone expiryAction = iota
+	expiryActionWarn
+	expiryActionStop
+	expiryActionDelete
+)
+
+// Works out what the reaper should do next with an instance given when it expires and how far the reaper has
+// already got with it. An instance that expired without being warned (ex. because RC3 was down) goes straight to
+// being stopped since stopping it loses nothing. Instances already being deleted are deleted again in case RC3
+// restarted part way through; the reaper skips the ones it is still deleting.
+func nextExpiryAction(
+	expires time.Time, stage string, now time.Time, warnBefore, deleteAfter time.Duration,
+) expiryAction {
+	switch stage {
+	case expiryStageNone:
+		if !now.Before(expires) {
+			return expiryActionStop
+		}
+		if !now.Before(expires.Add(-warnBefore)) {
+			return expiryActionWarn
+		}
+	case expiryStageWarned:
+		if !now.Before(expires) {
+			return expiryActionStop
+		}
+	case expiryStageStopped:
+		if !now.Before(expires.Add(deleteAfter)) {
+			return expiryActionDelete
+		}
+	case expiryStageDeleting:
+		return expiryActionDelete
+	}
+
+	return expiryActionNone
+}
+
+// Rejects negative durations and a default TTL longer than the max before the server starts.
+func validateExpiry(expiry *conf.Expiry) error {
+	if expiry.DefaultTTL < 0 || expiry.MaxTTL < 0 || expiry.WarnBefore < 0 || expiry.DeleteAfter < 0 {
+		return fmt.Errorf("expiry durations can't be negative")
+	}
+
+	if expiry.MaxTTL > 0 && expiry.DefaultTTL > expiry.MaxTTL {
+		return fmt.Errorf("default_ttl (%s) can't be longer than max_ttl (%s)", expiry.DefaultTTL, expiry.MaxTTL)
+	}
+
+	if expiry.ReapInterval <= 0 {
+		return fmt.Errorf("reap_interval must be positive")
+	}
+
+	return nil
+}
+
+// Works out when a new instance expires from how long its owner asked for it to live, falling back to the default.
+// Returns 0 if the instance never expires.
+func newInstanceExpiry(expiry *conf.Expiry, expiresIn string, now time.Time) (int64, error) {
+	ttl := expiry.DefaultTTL
+	if expiresIn != "" {
+		parsed, err := time.ParseDuration(expiresIn)
+		if err != nil || parsed <= 0 {
+			return 0, fmt.Errorf("expires_in must be a positive duration (ex. \"72h\")")
+		}
+		ttl = parsed
+	}
+
+	if expiry.MaxTTL > 0 {
+		if ttl > expiry.MaxTTL {
+			return 0, fmt.Errorf("instances can live for at most %s", expiry.MaxTTL)
+		}
+
+		// Having a maximum means every instance has to expire eventually.
+		if ttl == 0 {
+			ttl = expiry.MaxTTL
+		}
+	}
+
+	if ttl == 0 {
+		return 0, nil
+	}
+
+	return now.Add(ttl).Unix(), nil
+}
+
+// Works out when an instance expires after being extended. Extending an instance that has already expired extends it
+// from now rather than from when it expired.
+func extendExpiry(expiry *conf.Expiry, expires time.Time, by time.Duration, now time.Time) (time.Time, error) {
+	if by <= 0 {
+		return time.Time{}, fmt.Errorf("expires_in must be a positive duration (ex. \"24h\")")
+	}
+
+	if expires.Before(now) {
+		expires = now
+	}
+
+	extended := expires.Add(by)
+	if expiry.MaxTTL > 0 && extended.After(now.Add(expiry.MaxTTL)) {
+		return time.Time{}, fmt.Errorf("instances can't be set to expire more than %s from now", expiry.MaxTTL)
+	}
+
+	return extended, nil
+}
+
+// reaper cleans up expired instances. It warns owners when their instances are about to expire, stops them once
+// they have and deletes them if they still haven't been extended a while after that.
+type reaper struct {
+	api *APIContext
+	now func() time.Time // Tests set this to step instances through expiry without waiting days.
+
+	// Instances with a reap task still running. Guarded by api.expiryMu.
+	deleting map[uint64]bool
+}
+
+func newReaper(api *APIContext) *reaper {
+	return &reaper{
+		api:      api,
+		now:      time.Now,
+		deleting: map[uint64]bool{},
+	}
+}
+
+// Reaps once at startup and then on every tick. Never returns.
+func (r *reaper) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		r.reap(context.Background())
+		<-ticker.C
+	}
+}
+
+// Takes the next step with every instance that is close to expiring or has expired.
+func (r *reaper) reap(ctx context.Context) {
+	api := r.api
+	expiry := api.ExpiryConfig
+	now := r.now()
+
+	api.expiryMu.Lock()
+	defer api.expiryMu.Unlock()
+
+	records, err := api.DB.ListInstancesExpiringBefore(now.Add(expiry.WarnBefore).Unix())
+	if err != nil {
+		log.Error().Err(err).Msg("could not list expiring instances")
+		return
+	}
+
+	for _, record := range records {
+		expires := time.Unix(record.Expires, 0)
+
+		switch nextExpiryAction(expires, record.ExpiryStage, now, expiry.WarnBefore, expiry.DeleteAfter) {
+		case expiryActionWarn:
+			err = r.warn(record, expires, now)
+		case expiryActionStop:
+			err = r.stop(ctx, record, expires, now)
+		case expiryActionDelete:
+			err = r.delete(ctx, record)
+		default:
+			continue
+		}
+
+		if err != nil {
+			log.Error().Err(err).Uint64("instance_id", record.ID).Msg("could not reap instance")
+		}
+	}
+}
+
+func (r *reaper) warn(record storage.Instance, expires, now time.Time) error {
+	err := r.api.DB.UpdateInstanceExpiry(record.ID, record.Expires, expiryStageWarned)
+	if err != nil {
+		return err
+	}
+
+	r.api.recordEvent(record, EventKindExpiryWarning, now, fmt.Sprintf("instance %d expires at %s; extend it with "+
+		"POST /api/instances/%d/extend to keep it", record.ID, expires.UTC().Format(time.RFC3339), record.ID))
+
+	return nil
+}
+
+// Looks up an instance that is being reaped. If it no longer exists (ex. it was deleted directly in Proxmox) its
+// record is removed so the reaper stops looking at it and nil is returned.
+func (r *reaper) findInstance(ctx context.Context, record storage.Instance) (*proxmox.ClusterResource, error) {
+	resource, err := r.api.findInstance(ctx, record.ID)
+	if errors.Is(err, errInstanceNotFound) {
+		return nil, r.api.DB.DeleteInstance(record.ID)
+	}
+
+	return resource, err
+}
+
+func (r *reaper) stop(ctx context.Context, record storage.Instance, expires, now time.Time) error {
+	api := r.api
+
+	resource, err := r.findInstance(ctx, record)
+	if err != nil || resource == nil {
+		return err
+	}
+
+	if resource.Status == "running" {
+		_, err := api.startTask(AuthContext{RecurserID: record.RecurserID}, record.ID, "expire",
+			func(ctx context.Context, t *taskRun) error {
+				upid, err := api.performPowerAction(ctx, resource, PowerActionStop, PowerActionRequest{})
+				if err != nil {
+					return fmt.Errorf("could not stop instance: %w", err)
+				}
+
+				return t.wait(ctx, upid)
+			})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = api.DB.UpdateInstanceExpiry(record.ID, record.Expires, expiryStageStopped)
+	if err != nil {
+		return err
+	}
+
+	api.recordEvent(record, EventKindExpired, now, fmt.Sprintf("instance %d expired and was stopped; it will be "+
+		"deleted at %s unless it is extended", record.ID,
+		expires.Add(api.ExpiryConfig.DeleteAfter).UTC().Format(time.RFC3339)))
+
+	return nil
+}
+
+func (r *reaper) delete(ctx context.Context, record storage.Instance) error {
+	api := r.api
+
+	if r.deleting[record.ID] {
+		return nil
+	}
+
+	resource, err := r.findInstance(ctx, record)
+	if err != nil || resource == nil {
+		return err
+	}
+
+	err = api.DB.UpdateInstanceExpiry(record.ID, record.Expires, expiryStageDeleting)
+	if err != nil {
+		return err
+	}
+
+	r.deleting[record.ID] = true
+
+	_, err = api.startTask(AuthContext{RecurserID: record.RecurserID}, record.ID, "reap",
+		func(ctx context.Context, t *taskRun) error {
+			defer func() {
+				api.expiryMu.Lock()
+				delete(r.deleting, record.ID)
+				api.expiryMu.Unlock()
+			}()
+
+			err := api.destroyInstance(ctx, t, resource)
+			if err != nil {
+				// Put the instance back so the next pass tries again.
+				err := api.DB.UpdateInstanceExpiry(record.ID, record.Expires, expiryStageStopped)
+				if err != nil {
+					log.Error().Err(err).Uint64("instance_id", record.ID).Msg("could not reset expiry stage")
+				}
+				return err
+			}
+
+			api.recordEvent(record, EventKindReaped, r.now(), fmt.Sprintf("instance %d was deleted because it "+
+				"expired and wasn't extended", record.ID))
+
+			return nil
+		})
+	if err != nil {
+		// Nothing is going to delete the instance so put it back for the next pass.
+		delete(r.deleting, record.ID)
+		_ = api.DB.UpdateInstanceExpiry(record.ID, record.Expires, expiryStageStopped)
+		return err
+	}
+
+	return nil
+}
+
+type ExtendInstanceRequest struct {
+	// How much longer the instance should live (ex. "24h"). Added to the current expiry, or to now if the instance
+	// has already expired.
+	ExpiresIn string `json:"expires_in"`
+}
+
+type ExtendInstanceResponse struct {
+	Expires int64 `json:"expires"` // Unix seconds
+}
+
+func (api *APIContext) extendInstance(w http.ResponseWriter, r *http.Request) {
+	authCtx := CheckAuth(r)
+
+	resource := api.resolveManagedInstance(context.Background(), w, r)
+	if resource == nil {
+		return
+	}
+
+	var request ExtendInstanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	by, err := time.ParseDuration(request.ExpiresIn)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "expires_in must be a positive duration (ex. \"24h\")")
+		return
+	}
+
+	// Stops the reaper acting on the old expiry while it is being changed.
+	api.expiryMu.Lock()
+	defer api.expiryMu.Unlock()
+
+	record, err := api.DB.GetInstance(resource.VMID)
+	if err != nil {
+		if errors.Is(err, storage.ErrEntityNotFound) {
+			writeError(w, http.StatusConflict, fmt.Sprintf("instance %d wasn't created by RC3 and never expires",
+				resource.VMID))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get instance record: %v", err))
+		return
+	}
+
+	if record.Expires == 0 {
+		writeError(w, http.StatusConflict, fmt.Sprintf("instance %d never expires", resource.VMID))
+		return
+	}
+
+	if record.ExpiryStage == expiryStageDeleting {
+		writeError(w, http.StatusConflict, fmt.Sprintf("instance %d expired and is already being deleted",
+			resource.VMID))
+		return
+	}
+
+	now := time.Now()
+	expires, err := extendExpiry(api.ExpiryConfig, time.Unix(record.Expires, 0), by, now)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Going back to the first stage means the owner gets warned again before the new expiry.
+	err = api.DB.UpdateInstanceExpiry(record.ID, expires.Unix(), expiryStageNone)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not extend instance: %v", err))
+		return
+	}
+
+	api.recordEvent(*record, EventKindExtended, now, fmt.Sprintf("instance %d was extended by %s until %s",
+		record.ID, authCtx.RecurserID, expires.UTC().Format(time.RFC3339)))
+
+	writeResponse(w, http.StatusOK, ExtendInstanceResponse{
+		Expires: expires.Unix(),
+	})
+}
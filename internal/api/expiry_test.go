@@ -0,0 +1,311 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/clintjedwards/rc3/internal/conf"
+	"github.com/clintjedwards/rc3/internal/storage"
+)
+
+var testNow = time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+func TestNextExpiryAction(t *testing.T) {
+	const (
+		warnBefore  = 24 * time.Hour
+		deleteAfter = 72 * time.Hour
+	)
+
+	expires := testNow.Add(48 * time.Hour)
+	deletes := expires.Add(deleteAfter)
+
+	tests := map[string]struct {
+		now   time.Time
+		stage string
+		want  expiryAction
+	}{
+		"well before warning":        {now: testNow, stage: expiryStageNone, want: expiryActionNone},
+		"just before warning":        {now: expires.Add(-warnBefore - time.Second), want: expiryActionNone},
+		"at warning":                 {now: expires.Add(-warnBefore), want: expiryActionWarn},
+		"between warning and expiry": {now: expires.Add(-time.Hour), want: expiryActionWarn},
+		"warned before expiry":       {now: expires.Add(-time.Second), stage: expiryStageWarned},
+		"warned at expiry":           {now: expires, stage: expiryStageWarned, want: expiryActionStop},
+		"expired without warning":    {now: expires.Add(time.Hour), want: expiryActionStop},
+		"stopped before delete":      {now: expires.Add(deleteAfter - time.Second), stage: expiryStageStopped},
+		"stopped at delete":          {now: deletes, stage: expiryStageStopped, want: expiryActionDelete},
+		"stopped after delete":       {now: deletes.Add(time.Hour), stage: expiryStageStopped, want: expiryActionDelete},
+		"already deleting":           {now: deletes.Add(time.Hour), stage: expiryStageDeleting, want: expiryActionDelete},
+		"warned after delete":        {now: deletes.Add(time.Hour), stage: expiryStageWarned, want: expiryActionStop},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := nextExpiryAction(expires, test.stage, test.now, warnBefore, deleteAfter)
+			if got != test.want {
+				t.Errorf("got action %d; want %d", got, test.want)
+			}
+		})
+	}
+}
+
+// Walks an instance through its whole expiry an hour at a time, checking each action happens exactly once and in
+// order. Deleting is the last step; after that the instance is gone.
+func TestNextExpiryActionSequence(t *testing.T) {
+	const (
+		warnBefore  = 24 * time.Hour
+		deleteAfter = 72 * time.Hour
+	)
+
+	expires := testNow.Add(48 * time.Hour)
+	nextStage := map[expiryAction]string{
+		expiryActionWarn:   expiryStageWarned,
+		expiryActionStop:   expiryStageStopped,
+		expiryActionDelete: expiryStageDeleting,
+	}
+
+	stage := expiryStageNone
+	taken := map[expiryAction]time.Time{}
+	for now := testNow; now.Before(expires.Add(2 * deleteAfter)); now = now.Add(time.Hour) {
+		action := nextExpiryAction(expires, stage, now, warnBefore, deleteAfter)
+		if action == expiryActionNone {
+			continue
+		}
+
+		if _, ok := taken[action]; ok {
+			t.Fatalf("action %d happened again at %s", action, now)
+		}
+
+		taken[action] = now
+		stage = nextStage[action]
+
+		if action == expiryActionDelete {
+			break
+		}
+	}
+
+	want := map[expiryAction]time.Time{
+		expiryActionWarn:   expires.Add(-warnBefore),
+		expiryActionStop:   expires,
+		expiryActionDelete: expires.Add(deleteAfter),
+	}
+
+	for action, at := range want {
+		if !taken[action].Equal(at) {
+			t.Errorf("action %d happened at %s; want %s", action, taken[action], at)
+		}
+	}
+}
+
+func TestNewInstanceExpiry(t *testing.T) {
+	const (
+		week  = 168 * time.Hour
+		month = 720 * time.Hour
+	)
+
+	tests := map[string]struct {
+		defaultTTL, maxTTL time.Duration
+		expiresIn          string
+		want               time.Duration // 0 means never expires.
+		wantErr            bool
+	}{
+		"default":                      {defaultTTL: week, maxTTL: month, want: week},
+		"requested":                    {defaultTTL: week, maxTTL: month, expiresIn: "72h", want: 72 * time.Hour},
+		"requested at max":             {defaultTTL: week, maxTTL: month, expiresIn: "720h", want: month},
+		"requested past max":           {defaultTTL: week, maxTTL: month, expiresIn: "721h", wantErr: true},
+		"no default is clamped to max": {maxTTL: month, want: month},
+		"no default or max":            {},
+		"requested without max":        {expiresIn: "10000h", want: 10000 * time.Hour},
+		"invalid":                      {defaultTTL: week, expiresIn: "a week", wantErr: true},
+		"missing unit":                 {defaultTTL: week, expiresIn: "72", wantErr: true},
+		"zero":                         {defaultTTL: week, expiresIn: "0s", wantErr: true},
+		"negative":                     {defaultTTL: week, expiresIn: "-1h", wantErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			expiry := &conf.Expiry{DefaultTTL: test.defaultTTL, MaxTTL: test.maxTTL}
+
+			got, err := newInstanceExpiry(expiry, test.expiresIn, testNow)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("got expiry %d; want an error", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+
+			want := int64(0)
+			if test.want != 0 {
+				want = testNow.Add(test.want).Unix()
+			}
+
+			if got != want {
+				t.Errorf("got expiry %d; want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestExtendExpiry(t *testing.T) {
+	expiry := &conf.Expiry{MaxTTL: 720 * time.Hour}
+
+	tests := map[string]struct {
+		expires time.Time
+		by      time.Duration
+		want    time.Time
+		wantErr bool
+	}{
+		"extends from current expiry": {expires: testNow.Add(24 * time.Hour), by: 48 * time.Hour,
+			want: testNow.Add(72 * time.Hour)},
+		"extends expired from now": {expires: testNow.Add(-24 * time.Hour), by: 48 * time.Hour,
+			want: testNow.Add(48 * time.Hour)},
+		"up to max": {expires: testNow.Add(24 * time.Hour), by: 696 * time.Hour,
+			want: testNow.Add(720 * time.Hour)},
+		"past max": {expires: testNow.Add(24 * time.Hour), by: 697 * time.Hour, wantErr: true},
+		"zero":     {expires: testNow.Add(24 * time.Hour), by: 0, wantErr: true},
+		"negative": {expires: testNow.Add(24 * time.Hour), by: -time.Hour, wantErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := extendExpiry(expiry, test.expires, test.by, testNow)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("got expiry %s; want an error", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+
+			if !got.Equal(test.want) {
+				t.Errorf("got expiry %s; want %s", got, test.want)
+			}
+		})
+	}
+
+	got, err := extendExpiry(&conf.Expiry{}, testNow, 10000*time.Hour, testNow)
+	if err != nil || !got.Equal(testNow.Add(10000*time.Hour)) {
+		t.Errorf("got expiry %s, error %v without a max; want %s", got, err, testNow.Add(10000*time.Hour))
+	}
+}
+
+func TestReaperWarnsOnce(t *testing.T) {
+	db, err := storage.New(t.TempDir() + "/rc3.db")
+	if err != nil {
+		t.Fatalf("could not open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Migrate()
+	if err != nil {
+		t.Fatalf("could not migrate database: %v", err)
+	}
+
+	api := &APIContext{
+		DB: db,
+		ExpiryConfig: &conf.Expiry{
+			WarnBefore:  24 * time.Hour,
+			DeleteAfter: 72 * time.Hour,
+		},
+	}
+
+	instances := []storage.Instance{
+		{ID: 100, Name: "expiring", Expires: testNow.Add(12 * time.Hour).Unix()},
+		{ID: 101, Name: "later", Expires: testNow.Add(48 * time.Hour).Unix()},
+		{ID: 102, Name: "forever"},
+	}
+
+	for _, instance := range instances {
+		err := db.InsertInstance(&instance)
+		if err != nil {
+			t.Fatalf("could not insert instance: %v", err)
+		}
+	}
+
+	now := testNow
+	r := &reaper{api: api, now: func() time.Time { return now }}
+
+	// Only the instance inside the warning window is warned, and only the first time round.
+	for i := 0; i < 3; i++ {
+		r.reap(context.Background())
+		now = now.Add(time.Hour)
+	}
+
+	wantStages := map[uint64]string{100: expiryStageWarned, 101: expiryStageNone, 102: expiryStageNone}
+	wantWarnings := map[uint64]int{100: 1, 101: 0, 102: 0}
+
+	for id, want := range wantStages {
+		record, err := db.GetInstance(id)
+		if err != nil {
+			t.Fatalf("could not get instance %d: %v", id, err)
+		}
+
+		if record.ExpiryStage != want {
+			t.Errorf("instance %d is at stage %q; want %q", id, record.ExpiryStage, want)
+		}
+
+		events, err := db.ListInstanceEvents(id, 0)
+		if err != nil {
+			t.Fatalf("could not list events for instance %d: %v", id, err)
+		}
+
+		if len(events) != wantWarnings[id] {
+			t.Errorf("instance %d has %d events; want %d", id, len(events), wantWarnings[id])
+		}
+
+		for _, event := range events {
+			if event.Kind != string(EventKindExpiryWarning) {
+				t.Errorf("instance %d has a %q event; want only %q", id, event.Kind, EventKindExpiryWarning)
+			}
+		}
+	}
+}
+
+// Instances the reaper is still deleting are left alone rather than deleted twice. The API has no Proxmox client so
+// starting another delete would panic.
+func TestReaperSkipsInstancesItIsDeleting(t *testing.T) {
+	db, err := storage.New(t.TempDir() + "/rc3.db")
+	if err != nil {
+		t.Fatalf("could not open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Migrate()
+	if err != nil {
+		t.Fatalf("could not migrate database: %v", err)
+	}
+
+	api := &APIContext{
+		DB: db,
+		ExpiryConfig: &conf.Expiry{
+			WarnBefore:  24 * time.Hour,
+			DeleteAfter: 72 * time.Hour,
+		},
+	}
+
+	instance := storage.Instance{ID: 100, Name: "deleting", Expires: testNow.Add(-96 * time.Hour).Unix(),
+		ExpiryStage: expiryStageDeleting}
+	err = db.InsertInstance(&instance)
+	if err != nil {
+		t.Fatalf("could not insert instance: %v", err)
+	}
+
+	r := &reaper{api: api, now: func() time.Time { return testNow }, deleting: map[uint64]bool{100: true}}
+	r.reap(context.Background())
+
+	record, err := db.GetInstance(100)
+	if err != nil {
+		t.Fatalf("could not get instance: %v", err)
+	}
+
+	if record.ExpiryStage != expiryStageDeleting {
+		t.Errorf("instance is at stage %q; want %q", record.ExpiryStage, expiryStageDeleting)
+	}
+}
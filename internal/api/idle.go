@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/clintjedwards/rc3/internal/conf"
+	"github.com/clintjedwards/rc3/internal/storage"
+	"github.com/luthermonson/go-proxmox"
+	"github.com/rs/zerolog/log"
+)
+
+// What the idle monitor knows about an instance's recent activity.
+type activity struct {
+	network  uint64    // Total bytes in and out when last sampled.
+	sampled  time.Time // When the instance was last sampled.
+	activeAt time.Time // The last time the instance was seen doing something.
+}
+
+// Updates an instance's activity with a new sample and reports whether it has now been idle for the whole window.
+// Proxmox reports network traffic as a running total since the instance started, so the rate is worked out from the
+// difference between samples. Instances seen for the first time (or whose totals went backwards because they were
+// restarted) are treated as active so they always get a full window.
+func observeActivity(
+	previous activity, seen bool, cpu float64, network uint64, now time.Time, idle *conf.Idle,
+) (activity, bool) {
+	next := activity{
+		network:  network,
+		sampled:  now,
+		activeAt: previous.activeAt,
+	}
+
+	if !seen || network < previous.network {
+		next.activeAt = now
+		return next, false
+	}
+
+	var rate float64
+	if elapsed := now.Sub(previous.sampled).Seconds(); elapsed > 0 {
+		rate = float64(network-previous.network) / elapsed
+	}
+
+	if cpu >= idle.CPUThreshold || rate >= float64(idle.NetworkThreshold) {
+		next.activeAt = now
+	}
+
+	return next, now.Sub(next.activeAt) >= idle.Window
+}
+
+// Catches thresholds that could never be met and a missing sample interval when idle shutdowns are on.
+func validateIdle(idle *conf.Idle) error {
+	if idle.Window < 0 {
+		return fmt.Errorf("window can't be negative; use 0 to turn idle shutdowns off")
+	}
+
+	if idle.CPUThreshold < 0 || idle.CPUThreshold > 1 {
+		return fmt.Errorf("cpu_threshold must be between 0 and 1")
+	}
+
+	if idle.Window > 0 && idle.SampleInterval <= 0 {
+		return fmt.Errorf("sample_interval must be positive")
+	}
+
+	return nil
+}
+
+// idleMonitor shuts down instances that haven't done anything for a while. Activity is only kept in memory so a
+// restart gives every instance a fresh window.
+type idleMonitor struct {
+	api      *APIContext
+	activity map[uint64]activity
+}
+
+func newIdleMonitor(api *APIContext) *idleMonitor {
+	return &idleMonitor{
+		api:      api,
+		activity: map[uint64]activity{},
+	}
+}
+
+// Takes the first sample as soon as the monitor starts so a restart doesn't leave instances unwatched for an
+// interval.
+func (m *idleMonitor) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		m.sample(context.Background())
+		<-ticker.C
+	}
+}
+
+// Records how active every running instance is and shuts down the ones that have been idle for too long. Only
+// instances created by RC3 are looked at.
+func (m *idleMonitor) sample(ctx context.Context) {
+	api := m.api
+	now := time.Now()
+
+	cluster, err := api.Client.Cluster(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("could not get cluster to sample instance activity")
+		return
+	}
+
+	resources, err := cluster.Resources(ctx, "vm")
+	if err != nil {
+		log.Error().Err(err).Msg("could not query cluster resources to sample instance activity")
+		return
+	}
+
+	records, err := api.DB.ListInstances()
+	if err != nil {
+		log.Error().Err(err).Msg("could not list instance records to sample instance activity")
+		return
+	}
+
+	recordsByID := map[uint64]storage.Instance{}
+	for _, record := range records {
+		recordsByID[record.ID] = record
+	}
+
+	running := map[uint64]bool{}
+	for _, resource := range resources {
+		record, ok := recordsByID[resource.VMID]
+		if !ok || resource.Template == 1 || resource.Status != "running" || record.Pinned {
+			continue
+		}
+		running[resource.VMID] = true
+
+		previous, seen := m.activity[resource.VMID]
+		next, idle := observeActivity(previous, seen, resource.CPU, resource.NetIn+resource.NetOut, now,
+			api.IdleConfig)
+		m.activity[resource.VMID] = next
+
+		if !idle {
+			continue
+		}
+
+		err := m.shutdown(record, resource, next.activeAt, now)
+		if err != nil {
+			log.Error().Err(err).Uint64("instance_id", resource.VMID).Msg("could not shut down idle instance")
+			continue
+		}
+
+		delete(m.activity, resource.VMID)
+	}
+
+	// Instances that stopped, were pinned or were deleted start over if they come back.
+	for id := range m.activity {
+		if !running[id] {
+			delete(m.activity, id)
+		}
+	}
+}
+
+func (m *idleMonitor) shutdown(
+	record storage.Instance, resource *proxmox.ClusterResource, activeAt, now time.Time,
+) error {
+	api := m.api
+
+	_, err := api.startTask(AuthContext{RecurserID: record.RecurserID}, record.ID, "idle-shutdown",
+		func(ctx context.Context, t *taskRun) error {
+			upid, err := api.performPowerAction(ctx, resource, PowerActionShutdown, PowerActionRequest{})
+			if err != nil {
+				return fmt.Errorf("could not shut down instance: %w", err)
+			}
+
+			return t.wait(ctx, upid)
+		})
+	if err != nil {
+		return err
+	}
+
+	api.recordEvent(record, EventKindIdleShutdown, now, fmt.Sprintf("instance %d was shut down because it has been "+
+		"idle since %s (under %.0f%% CPU and %d bytes/s of network traffic); start it again when you need it or pin "+
+		"it to keep it on", record.ID, activeAt.UTC().Format(time.RFC3339), api.IdleConfig.CPUThreshold*100,
+		api.IdleConfig.NetworkThreshold))
+
+	return nil
+}
+
+type PinInstanceResponse struct {
+	Pinned bool `json:"pinned"`
+}
+
+// Returns a handler that pins or unpins an instance. Pinned instances are always-on and never shut down for being
+// idle.
+func (api *APIContext) pinInstanceHandler(pinned bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authCtx := CheckAuth(r)
+
+		resource := api.resolveManagedInstance(context.Background(), w, r)
+		if resource == nil {
+			return
+		}
+
+		record, err := api.DB.GetInstance(resource.VMID)
+		if err != nil {
+			if errors.Is(err, storage.ErrEntityNotFound) {
+				writeError(w, http.StatusConflict, fmt.Sprintf("instance %d wasn't created by RC3 and is never shut "+
+					"down for being idle", resource.VMID))
+				return
+			}
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get instance record: %v", err))
+			return
+		}
+
+		if record.Pinned != pinned {
+			err = api.DB.SetInstancePinned(record.ID, pinned)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not update instance: %v", err))
+				return
+			}
+
+			if pinned {
+				api.recordEvent(*record, EventKindPinned, time.Now(), fmt.Sprintf("instance %d was pinned by %s "+
+					"and will not be shut down for being idle", record.ID, authCtx.RecurserID))
+			} else {
+				api.recordEvent(*record, EventKindUnpinned, time.Now(), fmt.Sprintf("instance %d was unpinned by %s "+
+					"and will be shut down if it is idle", record.ID, authCtx.RecurserID))
+			}
+		}
+
+		writeResponse(w, http.StatusOK, PinInstanceResponse{
+			Pinned: pinned,
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/clintjedwards/rc3/internal/conf"
+)
+
+func TestObserveActivity(t *testing.T) {
+	idle := &conf.Idle{Window: time.Hour, CPUThreshold: 0.05, NetworkThreshold: 2048}
+
+	const (
+		interval = 5 * time.Minute
+		total    = 1000000
+	)
+
+	// Traffic that works out to exactly the network threshold over one interval.
+	busyTraffic := uint64(2048 * interval.Seconds())
+
+	tests := map[string]struct {
+		seen      bool
+		quietFor  time.Duration // How long before now the instance was last active.
+		sampled   time.Duration // How long before now the instance was last sampled.
+		cpu       float64
+		network   uint64
+		wantQuiet time.Duration // How long before now the instance should be recorded as last active.
+		wantIdle  bool
+	}{
+		"first sighting": {quietFor: 2 * time.Hour, sampled: interval, network: total},
+		"restarted":      {seen: true, quietFor: 2 * time.Hour, sampled: interval, network: total - 1},
+		"busy cpu":       {seen: true, quietFor: 2 * time.Hour, sampled: interval, cpu: 0.05, network: total},
+		"busy network":   {seen: true, quietFor: 2 * time.Hour, sampled: interval, network: total + busyTraffic},
+		"quiet network": {seen: true, quietFor: 30 * time.Minute, sampled: interval, network: total + busyTraffic - 1,
+			wantQuiet: 30 * time.Minute},
+		"quiet inside the window": {seen: true, quietFor: time.Hour - time.Second, sampled: interval, network: total,
+			cpu: 0.04, wantQuiet: time.Hour - time.Second},
+		"quiet for the whole window": {seen: true, quietFor: time.Hour, sampled: interval, network: total,
+			wantQuiet: time.Hour, wantIdle: true},
+		"sampled twice at once": {seen: true, quietFor: time.Hour, network: total + busyTraffic,
+			wantQuiet: time.Hour, wantIdle: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			previous := activity{
+				network:  total,
+				sampled:  testNow.Add(-test.sampled),
+				activeAt: testNow.Add(-test.quietFor),
+			}
+
+			got, gotIdle := observeActivity(previous, test.seen, test.cpu, test.network, testNow, idle)
+
+			if got.network != test.network || !got.sampled.Equal(testNow) {
+				t.Errorf("got sample (%d, %s); want (%d, %s)", got.network, got.sampled, test.network, testNow)
+			}
+
+			if want := testNow.Add(-test.wantQuiet); !got.activeAt.Equal(want) {
+				t.Errorf("got active at %s; want %s", got.activeAt, want)
+			}
+
+			if gotIdle != test.wantIdle {
+				t.Errorf("got idle %t; want %t", gotIdle, test.wantIdle)
+			}
+		})
+	}
+}
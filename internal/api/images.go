@@ -0,0 +1,157 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/clintjedwards/rc3/internal/conf"
+	"github.com/go-chi/chi/v5"
+)
+
+func (api *APIContext) imagesRouter() RouteEntry {
+	router := func(router chi.Router) {
+		router.Get("/", api.listImages)
+	}
+
+	return RouteEntry{
+		Pattern: "/images",
+		Router:  router,
+	}
+}
+
+// Image is an operating system recursers can choose when creating an instance.
+type Image struct {
+	ID      string         `json:"id"`
+	Name    string         `json:"name"`
+	OSType  string         `json:"os_type"`
+	Kinds   []InstanceType `json:"kinds"` // The kinds of instance that can use this image.
+	Default bool           `json:"default"`
+}
+
+// The kinds of instance an image can be used for. Images that don't list any can be used for whichever kinds they
+// have a template for.
+func imageKinds(image conf.Image) []InstanceType {
+	kinds := []InstanceType{}
+
+	if len(image.Kinds) > 0 {
+		for _, kind := range image.Kinds {
+			kinds = append(kinds, InstanceType(kind))
+		}
+		return kinds
+	}
+
+	if image.OSTemplate != "" {
+		kinds = append(kinds, InstanceTypeContainer)
+	}
+	if image.VMTemplateID != 0 {
+		kinds = append(kinds, InstanceTypeVM)
+	}
+
+	return kinds
+}
+
+func newImageFromConfig(image conf.Image) Image {
+	return Image{
+		ID:      image.ID,
+		Name:    image.Name,
+		OSType:  image.OSType,
+		Kinds:   imageKinds(image),
+		Default: image.Default,
+	}
+}
+
+// Makes sure the image catalog in the config is usable so mistakes are caught at startup rather than when someone
+// tries to create an instance.
+func validateImages(images []conf.Image) error {
+	if len(images) == 0 {
+		return fmt.Errorf("at least one image must be defined")
+	}
+
+	seen := map[string]bool{}
+	defaults := 0
+	for _, image := range images {
+		if image.ID == "" {
+			return fmt.Errorf("every image must have an id")
+		}
+
+		if seen[image.ID] {
+			return fmt.Errorf("image %q is defined more than once", image.ID)
+		}
+		seen[image.ID] = true
+
+		if image.Default {
+			defaults++
+		}
+
+		for _, kind := range image.Kinds {
+			switch InstanceType(kind) {
+			case InstanceTypeContainer:
+				if image.OSTemplate == "" {
+					return fmt.Errorf("image %q supports containers but has no os_template", image.ID)
+				}
+			case InstanceTypeVM:
+				if image.VMTemplateID == 0 {
+					return fmt.Errorf("image %q supports vms but has no vm_template_id", image.ID)
+				}
+			default:
+				return fmt.Errorf("image %q has an invalid kind %q; must be %q or %q", image.ID, kind,
+					InstanceTypeContainer, InstanceTypeVM)
+			}
+		}
+	}
+
+	if defaults > 1 {
+		return fmt.Errorf("only one image can be the default")
+	}
+
+	return nil
+}
+
+// Looks up an image in the catalog, making sure it can be used for the kind of instance being created. An empty ID
+// picks the default image.
+func (api *APIContext) lookupImage(id string, kind InstanceType) (conf.Image, error) {
+	ids := []string{}
+	for _, image := range api.Images {
+		if id == "" && !image.Default {
+			continue
+		}
+
+		if id != "" && image.ID != id {
+			ids = append(ids, image.ID)
+			continue
+		}
+
+		if !slices.Contains(imageKinds(image), kind) {
+			if id == "" {
+				return conf.Image{}, fmt.Errorf("the default image %q is not available for %s instances; an image "+
+					"must be chosen", image.ID, kind)
+			}
+			return conf.Image{}, fmt.Errorf("image %q is not available for %s instances", image.ID, kind)
+		}
+
+		return image, nil
+	}
+
+	if id == "" {
+		return conf.Image{}, fmt.Errorf("no default image is configured; an image must be chosen")
+	}
+
+	return conf.Image{}, fmt.Errorf("unknown image %q; must be one of: %s", id, strings.Join(ids, ", "))
+}
+
+type ListImagesResponse struct {
+	Images []Image `json:"images"`
+}
+
+func (api *APIContext) listImages(w http.ResponseWriter, r *http.Request) {
+	images := []Image{}
+	for _, image := range api.Images {
+		images = append(images, newImageFromConfig(image))
+	}
+
+	writeResponse(w, http.StatusOK, ListImagesResponse{
+		Images: images,
+	})
+}
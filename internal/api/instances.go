@@ -3,20 +3,47 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/clintjedwards/rc3/internal/conf"
+	"github.com/clintjedwards/rc3/internal/placement"
+	"github.com/clintjedwards/rc3/internal/storage"
 	"github.com/go-chi/chi/v5"
 	"github.com/luthermonson/go-proxmox"
+	"github.com/rs/zerolog/log"
 )
 
 func (api *APIContext) instancesRouter() RouteEntry {
 	router := func(router chi.Router) {
 		router.Get("/", api.getInstances)
 		router.Post("/", api.createInstance)
+		router.Get("/{id}", api.getInstance)
 		router.Delete("/{id}", api.deleteInstance)
+		router.Patch("/{id}", api.resizeInstance)
+		router.Post("/{id}/clone", api.cloneInstance)
+		router.Post("/{id}/extend", api.extendInstance)
+		router.Get("/{id}/console", api.openConsole)
+		router.Get("/{id}/events", api.listInstanceEvents)
+		router.Put("/{id}/pin", api.pinInstanceHandler(true))
+		router.Delete("/{id}/pin", api.pinInstanceHandler(false))
+		router.Get("/{id}/snapshots", api.listSnapshots)
+		router.Post("/{id}/snapshots", api.createSnapshot)
+		router.Delete("/{id}/snapshots/{name}", api.deleteSnapshot)
+		router.Post("/{id}/snapshots/{name}/rollback", api.rollbackSnapshot)
+		router.Get("/{id}/backups", api.listInstanceBackups)
+		router.Post("/{id}/backups", api.createBackup)
+		router.Get("/{id}/backup-policy", api.getBackupPolicy)
+		router.Put("/{id}/backup-policy", api.setBackupPolicy)
+		router.Delete("/{id}/backup-policy", api.deleteBackupPolicy)
+
+		for _, action := range powerActions {
+			router.Post("/{id}/"+string(action), api.powerActionHandler(action))
+		}
 	}
 
 	return RouteEntry{
@@ -47,80 +74,47 @@ func (it *InstanceType) UnmarshalJSON(b []byte) error {
 	}
 }
 
-type InstanceSize string
+// The name Proxmox uses for this kind of instance in its API paths and cluster resources.
+func (it InstanceType) resourceType() string {
+	if it == InstanceTypeVM {
+		return "qemu"
+	}
 
-const (
-	InstanceSizeSmall  InstanceSize = "small"
-	InstanceSizeMedium InstanceSize = "medium"
-	InstanceSizeLarge  InstanceSize = "large"
-)
+	return "lxc"
+}
+
+// The kind of instance a Proxmox resource type ("lxc" or "qemu") is.
+func instanceTypeFromResource(resourceType string) InstanceType {
+	if resourceType == "qemu" {
+		return InstanceTypeVM
+	}
+
+	return InstanceTypeContainer
+}
+
+type InstanceSize string
 
-func (api *APIContext) getContainerOptions(size InstanceSize) ([]proxmox.ContainerOption, error) {
-	// Common settings
+func (api *APIContext) getContainerOptions(size conf.Size, image conf.Image) []proxmox.ContainerOption {
 	options := []proxmox.ContainerOption{
 		{Name: "arch", Value: "amd64"},
 		{Name: "onboot", Value: 1}, // Start on boot
-		{Name: "ostype", Value: "ubuntu"},
 		{Name: "unprivileged", Value: true},
 		{Name: "features", Value: "nesting=1"},
-		{Name: "ostemplate", Value: api.ProxmoxConfig.OSTemplate},
+		{Name: "ostemplate", Value: image.OSTemplate},
 		{Name: "net0", Value: "name=eth0,bridge=vmbr0,firewall=0,ip=dhcp"},
-		{Name: "rootfs", Value: fmt.Sprintf("%s,size=60", api.ProxmoxConfig.InstanceStorage)}, // 60 GB of storage
-	}
-
-	switch size {
-	case InstanceSizeSmall:
-		options = append(options,
-			proxmox.ContainerOption{Name: "cores", Value: 2},
-			proxmox.ContainerOption{Name: "cpulimit", Value: 2},
-			proxmox.ContainerOption{Name: "memory", Value: "2048"}, // 2 GB of memory
-		)
-		return options, nil
-	case InstanceSizeMedium:
-		options = append(options,
-			proxmox.ContainerOption{Name: "cores", Value: 2},
-			proxmox.ContainerOption{Name: "cpulimit", Value: 2},
-			proxmox.ContainerOption{Name: "memory", Value: "4096"}, // 4 GB of memory
-		)
-		return options, nil
-	case InstanceSizeLarge:
-		options = append(options,
-			proxmox.ContainerOption{Name: "cores", Value: 4},
-			proxmox.ContainerOption{Name: "cpulimit", Value: 4},
-			proxmox.ContainerOption{Name: "memory", Value: "8192"}, // 8 GB of memory
-		)
-		return options, nil
-	default:
-		return nil, fmt.Errorf("invalid instance size")
-	}
-}
-
-// Add tags to a container option list.
-func createTagsContainerOption(tags ...string) proxmox.ContainerOption {
-	tagStr := ""
-
-	for _, tag := range tags {
-		tagStr += tag + ","
+		{Name: "rootfs", Value: fmt.Sprintf("%s,size=%d", api.ProxmoxConfig.InstanceStorage, size.Disk)},
+		{Name: "cores", Value: size.Cores},
+		{Name: "cpulimit", Value: size.CPULimit},
+		{Name: "memory", Value: strconv.Itoa(size.Memory)},
+		{Name: "swap", Value: strconv.Itoa(size.Swap)},
 	}
 
-	tagStr = strings.TrimSuffix(tagStr, ",")
-
-	return proxmox.ContainerOption{Name: "tags", Value: tagStr}
-}
-
-func (is *InstanceSize) UnmarshalJSON(b []byte) error {
-	var str string
-	if err := json.Unmarshal(b, &str); err != nil {
-		return err
+	// Proxmox works out the ostype from the template when it isn't given.
+	if image.OSType != "" {
+		options = append(options, proxmox.ContainerOption{Name: "ostype", Value: image.OSType})
 	}
 
-	switch InstanceSize(str) {
-	case InstanceSizeSmall, InstanceSizeMedium, InstanceSizeLarge:
-		*is = InstanceSize(str)
-		return nil
-	default:
-		return fmt.Errorf("invalid InstanceSize: %s", str)
-	}
+	return options
 }
 
 type Instance struct {
@@ -132,6 +126,57 @@ type Instance struct {
 	Status   string       `json:"status"`
 	Uptime   uint64       `json:"uptime"`
 	Recurser string       `json:"recurser"`
+	User     string       `json:"user"`    // The user to log in as over SSH.
+	Expires  int64        `json:"expires"` // Unix seconds; 0 means the instance never expires.
+	Pinned   bool         `json:"pinned"`  // Pinned instances are never shut down for being idle.
+
+	// Only running instances have addresses. They may take a little while to show up after an instance starts.
+	Addresses
+}
+
+// Works out an instance's size and owner. RC3's own record is trusted first since tags can be edited by anyone with
+// access to Proxmox; the tags cover instances we have no record of.
+func instanceMetadata(tags string, record storage.Instance) (InstanceSize, string) {
+	parsedTags := parseTags(tags)
+
+	size := record.Size
+	if size == "" {
+		size = parsedTags[tagKeySize]
+	}
+
+	recurser := record.RecurserID
+	if recurser == "" {
+		recurser = parsedTags[tagKeyRecurser]
+	}
+
+	return InstanceSize(size), recurser
+}
+
+// Works out the user recursers should log in to an instance as. Containers only have root; VMs get whichever user
+// cloud-init created from the image they were made from.
+func (api *APIContext) instanceUser(kind InstanceType, record storage.Instance) string {
+	if kind == InstanceTypeContainer {
+		return "root"
+	}
+
+	var request CreateInstanceRequest
+	if record.Settings != "" {
+		err := json.Unmarshal([]byte(record.Settings), &request)
+		if err != nil {
+			log.Debug().Err(err).Uint64("id", record.ID).Msg("could not decode instance settings")
+		}
+	}
+
+	for _, image := range api.Images {
+		if (request.Image == "" && image.Default) || (request.Image != "" && image.ID == request.Image) {
+			if image.User != "" {
+				return image.User
+			}
+			break
+		}
+	}
+
+	return api.ProxmoxConfig.VMUser
 }
 
 type GetInstancesResponse struct {
@@ -143,6 +188,20 @@ func (api *APIContext) getInstances(w http.ResponseWriter, r *http.Request) {
 
 	returnedInstances := []Instance{}
 
+	// Proxmox doesn't know who owns an instance or what size it was created as, so we fill that in from our own
+	// records.
+	records, err := api.DB.ListInstances()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError,
+			fmt.Sprintf("could not query instance records while attempting to get instances: %v", err))
+		return
+	}
+
+	recordsByID := map[uint64]storage.Instance{}
+	for _, record := range records {
+		recordsByID[record.ID] = record
+	}
+
 	nodes, err := api.Client.Nodes(ctx)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError,
@@ -168,15 +227,21 @@ func (api *APIContext) getInstances(w http.ResponseWriter, r *http.Request) {
 		}
 
 		for _, container := range containers {
+			record := recordsByID[uint64(container.VMID)]
+			size, recurser := instanceMetadata(container.Tags, record)
+
 			newInstance := Instance{
 				ID:       uint64(container.VMID),
 				Kind:     InstanceTypeContainer,
-				Size:     "", // We need to store a tag somewhere to repeat size.
+				Size:     size,
 				Name:     container.Name,
 				Node:     container.Node,
 				Status:   container.Status,
 				Uptime:   container.Uptime,
-				Recurser: "", // We need to store a tag somewhere to identify recurser.
+				Recurser: recurser,
+				User:     api.instanceUser(InstanceTypeContainer, record),
+				Expires:  record.Expires,
+				Pinned:   record.Pinned,
 			}
 
 			returnedInstances = append(returnedInstances, newInstance)
@@ -190,15 +255,26 @@ func (api *APIContext) getInstances(w http.ResponseWriter, r *http.Request) {
 		}
 
 		for _, vm := range vms {
+			// Templates (like the one VMs are cloned from) can't be run so they aren't instances.
+			if vm.Template {
+				continue
+			}
+
+			record := recordsByID[uint64(vm.VMID)]
+			size, recurser := instanceMetadata(vm.Tags, record)
+
 			newInstance := Instance{
 				ID:       uint64(vm.VMID),
 				Kind:     InstanceTypeVM,
-				Size:     "", // We need to store a tag somewhere to repeat size.
+				Size:     size,
 				Name:     vm.Name,
 				Node:     vm.Node,
 				Status:   vm.Status,
 				Uptime:   vm.Uptime,
-				Recurser: "", // We need to store a tag somewhere to identify recurser.
+				Recurser: recurser,
+				User:     api.instanceUser(InstanceTypeVM, record),
+				Expires:  record.Expires,
+				Pinned:   record.Pinned,
 			}
 
 			returnedInstances = append(returnedInstances, newInstance)
@@ -206,20 +282,418 @@ func (api *APIContext) getInstances(w http.ResponseWriter, r *http.Request) {
 
 	}
 
+	api.fillAddresses(ctx, returnedInstances)
+
 	writeResponse(w, http.StatusOK, GetInstancesResponse{
 		Instances: returnedInstances,
 	})
 }
 
+// The live state of an instance as reported by Proxmox's status endpoint.
+type instanceStatus struct {
+	Status    string  `json:"status"`
+	Uptime    uint64  `json:"uptime"`
+	CPU       float64 `json:"cpu"`
+	CPUs      float64 `json:"cpus"`
+	Mem       uint64  `json:"mem"`
+	MaxMem    uint64  `json:"maxmem"`
+	Disk      uint64  `json:"disk"`
+	MaxDisk   uint64  `json:"maxdisk"`
+	NetIn     uint64  `json:"netin"`
+	NetOut    uint64  `json:"netout"`
+	DiskRead  uint64  `json:"diskread"`
+	DiskWrite uint64  `json:"diskwrite"`
+}
+
+// InstanceUsage is a snapshot of the resources an instance is currently using.
+type InstanceUsage struct {
+	CPU            float64 `json:"cpu"` // Fraction of the instance's allotted CPU in use (0.0 - 1.0).
+	MemoryBytes    uint64  `json:"memory_bytes"`
+	MaxMemoryBytes uint64  `json:"max_memory_bytes"`
+	DiskBytes      uint64  `json:"disk_bytes"`
+	MaxDiskBytes   uint64  `json:"max_disk_bytes"`
+	NetInBytes     uint64  `json:"net_in_bytes"`  // Total since the instance was started.
+	NetOutBytes    uint64  `json:"net_out_bytes"` // Total since the instance was started.
+	DiskReadBytes  uint64  `json:"disk_read_bytes"`
+	DiskWriteBytes uint64  `json:"disk_write_bytes"`
+}
+
+type InstanceDetail struct {
+	Instance
+
+	MAC      string        `json:"mac"`
+	Cores    int           `json:"cores"`
+	MemoryMB int           `json:"memory_mb"`
+	Disk     string        `json:"disk"`     // Size of the root disk as reported by Proxmox (ex. "60G").
+	OSType   string        `json:"os_type"`  // ex. "ubuntu" for containers, "l26" for Linux VMs.
+	Template string        `json:"template"` // The OS template or VM template the instance was created from.
+	Created  int64         `json:"created"`  // Unix seconds; 0 if the instance wasn't created by RC3.
+	Usage    InstanceUsage `json:"usage"`
+}
+
+type GetInstanceResponse struct {
+	Instance InstanceDetail `json:"instance"`
+}
+
+// Pulls a single "key=value" setting out of a Proxmox property string like
+// "local-lvm:vm-100-disk-0,size=60G".
+func propertyValue(property, key string) string {
+	for _, part := range strings.Split(property, ",") {
+		k, v, found := strings.Cut(part, "=")
+		if found && k == key {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// Network devices are "hwaddr=<mac>,..." for containers and "<model>=<mac>,..." for VMs.
+func macFromNetDevice(kind InstanceType, netDevice string) string {
+	if kind == InstanceTypeContainer {
+		return propertyValue(netDevice, "hwaddr")
+	}
+
+	model, _, _ := strings.Cut(netDevice, ",")
+	_, mac, _ := strings.Cut(model, "=")
+	return mac
+}
+
+func (api *APIContext) getInstance(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	resource := api.resolveInstance(ctx, w, r)
+	if resource == nil {
+		return
+	}
+
+	var status instanceStatus
+	err := api.Client.Get(ctx, instancePath(resource)+"/status/current", &status)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get instance status: %v", err))
+		return
+	}
+
+	config := map[string]any{}
+	err = api.Client.Get(ctx, instancePath(resource)+"/config", &config)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get instance config: %v", err))
+		return
+	}
+
+	record, err := api.DB.GetInstance(resource.VMID)
+	if err != nil && !errors.Is(err, storage.ErrEntityNotFound) {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get instance record: %v", err))
+		return
+	}
+
+	if record == nil {
+		record = &storage.Instance{}
+	}
+
+	kind := InstanceTypeContainer
+	rootDisk := "rootfs"
+	if resource.Type == "qemu" {
+		kind = InstanceTypeVM
+		rootDisk = api.ProxmoxConfig.VMRootDisk
+	}
+
+	size, recurser := instanceMetadata(resource.Tags, *record)
+
+	configString := func(key string) string {
+		value, ok := config[key]
+		if !ok {
+			return ""
+		}
+		return fmt.Sprint(value)
+	}
+
+	cores, _ := strconv.Atoi(configString("cores"))
+	memory, _ := strconv.Atoi(configString("memory"))
+
+	detail := InstanceDetail{
+		Instance: Instance{
+			ID:       resource.VMID,
+			Kind:     kind,
+			Size:     size,
+			Name:     resource.Name,
+			Node:     resource.Node,
+			Status:   status.Status,
+			Uptime:   status.Uptime,
+			Recurser: recurser,
+			User:     api.instanceUser(kind, *record),
+			Expires:  record.Expires,
+			Pinned:   record.Pinned,
+		},
+		MAC:      macFromNetDevice(kind, configString("net0")),
+		Cores:    cores,
+		MemoryMB: memory,
+		Disk:     propertyValue(configString(rootDisk), "size"),
+		OSType:   configString("ostype"),
+		Template: record.Template,
+		Created:  record.Created,
+		Usage: InstanceUsage{
+			CPU:            status.CPU,
+			MemoryBytes:    status.Mem,
+			MaxMemoryBytes: status.MaxMem,
+			DiskBytes:      status.Disk,
+			MaxDiskBytes:   status.MaxDisk,
+			NetInBytes:     status.NetIn,
+			NetOutBytes:    status.NetOut,
+			DiskReadBytes:  status.DiskRead,
+			DiskWriteBytes: status.DiskWrite,
+		},
+	}
+
+	resource.Status = status.Status
+	detail.Addresses = api.instanceAddresses(ctx, resource)
+
+	writeResponse(w, http.StatusOK, GetInstanceResponse{
+		Instance: detail,
+	})
+}
+
 type CreateInstanceRequest struct {
 	Size         InstanceSize `json:"size"`
 	InstanceType InstanceType `json:"type"`
+
+	// Used as the instance's hostname so it must be a valid DNS label that no other instance is using. A name is
+	// generated if omitted.
+	Name string `json:"name,omitempty"`
+
+	// The ID of the operating system image to use (see /api/images). The default image is used if omitted.
+	Image string `json:"image,omitempty"`
+
+	// Public keys that should be allowed to log in to the instance on top of the ones the recurser has registered
+	// (see /api/keys).
+	SSHKeys []string `json:"ssh_keys,omitempty"`
+
+	// How long the instance should live before it is stopped and later deleted (ex. "72h"). Uses the configured
+	// default if omitted.
+	ExpiresIn string `json:"expires_in,omitempty"`
+}
+
+type CreateInstanceResponse struct {
+	Instance Instance `json:"instance"`
+
+	// The task creating the instance. The instance isn't ready to use until the task succeeds.
+	TaskID string `json:"task_id"`
+}
+
+// The status reported for a new instance until the task creating it finishes.
+const instanceStatusCreating = "creating"
+
+// Responds to a create request with the new instance and the task creating it. If the caller waited for the task to
+// finish the instance is looked up again so its status reflects what actually exists.
+func (api *APIContext) writeCreatedInstance(w http.ResponseWriter, r *http.Request, task *storage.Task,
+	instance Instance,
+) {
+	w.Header().Set("Location", fmt.Sprintf("/api/instances/%d", instance.ID))
+
+	record, err := api.DB.GetInstance(instance.ID)
+	if err != nil {
+		record = &storage.Instance{}
+	}
+	instance.User = api.instanceUser(instance.Kind, *record)
+	instance.Expires = record.Expires
+	instance.Pinned = record.Pinned
+	instance.Addresses = Addresses{IPv4: []string{}, IPv6: []string{}}
+
+	api.writeTaskResponse(w, r, task, http.StatusCreated, func(task Task) any {
+		if task.Status == TaskStatusSucceeded {
+			resource, err := api.findInstance(r.Context(), instance.ID)
+			if err == nil {
+				instance.Name = resource.Name
+				instance.Node = resource.Node
+				instance.Status = resource.Status
+				instance.Uptime = resource.Uptime
+				instance.Addresses = api.instanceAddresses(r.Context(), resource)
+			}
+		}
+
+		return CreateInstanceResponse{
+			Instance: instance,
+			TaskID:   task.ID,
+		}
+	})
+}
+
+// Saves RC3's own record of a newly created instance so we can later answer who owns it and how it was created.
+func (api *APIContext) recordInstance(id uint64, authCtx AuthContext, node, template string, expires int64,
+	request CreateInstanceRequest,
+) error {
+	settings, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	return api.DB.InsertInstance(&storage.Instance{
+		ID:         id,
+		RecurserID: authCtx.RecurserID,
+		Name:       request.Name,
+		Kind:       string(request.InstanceType),
+		Size:       string(request.Size),
+		Template:   template,
+		Node:       node,
+		Created:    time.Now().Unix(),
+		Settings:   string(settings),
+		Expires:    expires,
+	})
+}
+
+// How many IDs reserveInstance tries before giving up. Each retry means another request reserved the ID first, so
+// running out takes a lot of instances being created at once.
+const maxReserveAttempts = 5
+
+// Finds the first ID after the given one that Proxmox says is free. IDs reserved by RC3 but not yet created don't
+// show up in Proxmox's own next ID so this is how we step past them.
+func (api *APIContext) nextFreeID(ctx context.Context, after int) (int, error) {
+	var lastErr error
+	for id := after + 1; id <= after+maxReserveAttempts; id++ {
+		// Proxmox errors if the ID is taken and echoes it back if it isn't.
+		var free string
+		err := api.Client.Get(ctx, fmt.Sprintf("/cluster/nextid?vmid=%d", id), &free)
+		if err == nil {
+			return id, nil
+		}
+		lastErr = err
+	}
+
+	return 0, fmt.Errorf("no free id found after %d: %w", after, lastErr)
+}
+
+// Picks an ID for a new instance and records the instance under it before it is created so its ID and name are
+// claimed, and its resources counted against its owner's quota, while Proxmox works on it. Proxmox only knows about
+// IDs once something is created with them, so if another request has already reserved the ID Proxmox suggests the
+// next free one is tried instead. If the name is taken, the instance doesn't fit in the owner's quota or the record
+// can't be saved the appropriate error is written and false is returned.
+func (api *APIContext) reserveInstance(ctx context.Context, w http.ResponseWriter, authCtx AuthContext, node,
+	template string, size conf.Size, expires int64, request CreateInstanceRequest,
+) (int, bool) {
+	cluster, err := api.Client.Cluster(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get cluster: %v", err))
+		return 0, false
+	}
+
+	// Proxmox gives us an endpoint we can hit to get the next sequential ID for the next instance.
+	id, err := cluster.NextID(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError,
+			fmt.Sprintf("could not get next id for instance from cluster: %v", err))
+		return 0, false
+	}
+
+	for attempt := 1; ; attempt++ {
+		idTaken := false
+
+		ok := api.withinQuota(w, authCtx, sizeUsage(size), func() bool {
+			_, err := api.DB.GetInstanceByName(request.Name)
+			if err == nil {
+				writeError(w, http.StatusConflict, fmt.Sprintf("an instance named %q already exists", request.Name))
+				return false
+			}
+			if !errors.Is(err, storage.ErrEntityNotFound) {
+				writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not check instance name: %v", err))
+				return false
+			}
+
+			err = api.recordInstance(uint64(id), authCtx, node, template, expires, request)
+			if err == nil {
+				return true
+			}
+
+			if !errors.Is(err, storage.ErrEntityExists) {
+				writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not record instance: %v", err))
+				return false
+			}
+
+			// The name was free a moment ago, so the conflict is the ID unless its record is missing.
+			_, err = api.DB.GetInstance(uint64(id))
+			if err == nil {
+				idTaken = true
+				return false
+			}
+
+			writeError(w, http.StatusConflict, fmt.Sprintf("an instance named %q already exists", request.Name))
+			return false
+		})
+		if ok {
+			return id, true
+		}
+
+		if !idTaken {
+			return 0, false
+		}
+
+		if attempt == maxReserveAttempts {
+			writeError(w, http.StatusServiceUnavailable, fmt.Sprintf("could not reserve an id for the instance after "+
+				"%d attempts; try again", attempt))
+			return 0, false
+		}
+
+		log.Debug().Int("id", id).Msg("instance id already reserved; trying the next one")
+
+		id, err = api.nextFreeID(ctx, id)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get next id for instance: %v", err))
+			return 0, false
+		}
+	}
+}
+
+// Removes RC3's record of an instance whose creation failed, as long as Proxmox didn't leave anything behind. Proxmox
+// hands out the IDs of failed instances again so a leftover record would belong to whatever gets that ID next.
+func (api *APIContext) forgetFailedInstance(ctx context.Context, id uint64) {
+	_, err := api.findInstance(ctx, id)
+	if !errors.Is(err, errInstanceNotFound) {
+		return
+	}
+
+	err = api.DB.DeleteInstance(id)
+	if err != nil && !errors.Is(err, storage.ErrEntityNotFound) {
+		log.Error().Err(err).Uint64("id", id).Msg("could not remove record of failed instance")
+	}
 }
 
-type CreateInstanceResponse struct{}
+// Cleans up after an instance whose setup failed part way through in the background. Anything Proxmox already created
+// (ex. a cloned VM that then couldn't be configured or started) is stopped and destroyed so it doesn't sit around
+// taking up its owner's quota, then RC3's record of it is removed. If the instance can't be looked up or destroyed its
+// record is kept so it is still counted and its owner can delete it themselves.
+//
+// Nothing is touched if setup failed because a Proxmox task didn't finish in time; it may still be working on the
+// instance (ex. copying its disk) and would fail to be destroyed or, worse, finish after its record was removed.
+func (api *APIContext) cleanUpFailedInstance(ctx context.Context, t *taskRun, id uint64, cause error) {
+	if errors.Is(cause, errTaskUnfinished) {
+		t.logf("leaving instance %d as is since Proxmox may still be working on it; delete it if it isn't needed", id)
+		return
+	}
+
+	resource, err := api.findInstance(ctx, id)
+	if errors.Is(err, errInstanceNotFound) {
+		api.forgetFailedInstance(ctx, id)
+		return
+	}
+
+	if err != nil {
+		log.Error().Err(err).Uint64("id", id).Msg("could not look up partially created instance")
+		t.logf("could not check whether instance %d was partially created; delete it to free up its resources: %v",
+			id, err)
+		return
+	}
+
+	t.logf("removing partially created instance %d", id)
+
+	err = api.destroyInstance(ctx, t, resource)
+	if err != nil {
+		log.Error().Err(err).Uint64("id", id).Msg("could not remove partially created instance")
+		t.logf("could not remove partially created instance %d; delete it to free up its resources: %v", id, err)
+	}
+}
 
 func (api *APIContext) createInstance(w http.ResponseWriter, r *http.Request) {
 	ctx := context.Background()
+	authCtx := CheckAuth(r)
 
 	var request CreateInstanceRequest
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -227,123 +701,330 @@ func (api *APIContext) createInstance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	nodes, err := api.Client.Nodes(ctx)
-	if err != nil {
-		writeError(w, http.StatusInternalServerError,
-			fmt.Sprintf("could not query for nodes while attempting to get instances: %v", err))
+	if request.InstanceType == "" {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("type is required; must be %q or %q",
+			InstanceTypeContainer, InstanceTypeVM))
 		return
 	}
 
-	if len(nodes) == 0 {
-		writeError(w, http.StatusInternalServerError,
-			fmt.Sprintf("received no proxmox nodes while attempting to create instance: %v", err))
+	size, err := api.lookupSize(request.Size, request.InstanceType)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid size: %v", err))
 		return
+	}
 
+	image, err := api.lookupImage(request.Image, request.InstanceType)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid image: %v", err))
+		return
 	}
 
-	// We default to the first node that we find since that will work for the single proxmox instance we have.
-	// If we ever expand the proxmox cluster then we'll have to change this logic.
-	targetNodeName := nodes[0].Node
+	sshKeys, err := api.instanceSSHKeys(authCtx, request.SSHKeys)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	node, err := api.Client.Node(ctx, targetNodeName)
+	expires, err := newInstanceExpiry(api.ExpiryConfig, request.ExpiresIn, time.Now())
 	if err != nil {
-		writeError(w, http.StatusInternalServerError,
-			fmt.Sprintf("could not get target node %s: %v", targetNodeName, err))
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// We need to query the cluster in order to figure out what the next VMID should be.
-	cluster, err := api.Client.Cluster(ctx)
+	name, ok := api.chooseInstanceName(ctx, w, request.Name)
+	if !ok {
+		return
+	}
+	request.Name = name
+
+	targetNodeName, err := api.placeInstance(ctx, size)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError,
-			fmt.Sprintf("could not get cluster: %v", err))
+		if errors.Is(err, placement.ErrNoCapacity) {
+			writeError(w, http.StatusServiceUnavailable, fmt.Sprintf("could not find a node for the instance: %v", err))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not choose a node for the instance: %v", err))
 		return
 	}
 
-	// Proxmox gives us an endpoint we can hit to get the next sequential ID for the next instance.
-	nextID, err := cluster.NextID(ctx)
+	node, err := api.Client.Node(ctx, targetNodeName)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError,
-			fmt.Sprintf("could not get next id for instance from cluster: %v", err))
+			fmt.Sprintf("could not get target node %s: %v", targetNodeName, err))
 		return
 	}
 
 	switch request.InstanceType {
 	case InstanceTypeContainer:
-		containerOptions, err := api.getContainerOptions(request.Size)
-		if err != nil {
-			writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get container settings: %v", err))
-			return
+		containerOptions := append(api.getContainerOptions(size, image),
+			proxmox.ContainerOption{Name: "hostname", Value: request.Name},
+			createTagsContainerOption(
+				encodeTag(tagKeySize, string(request.Size)),
+				encodeTag(tagKeyRecurser, authCtx.RecurserID),
+			))
+
+		if len(sshKeys) > 0 {
+			containerOptions = append(containerOptions,
+				proxmox.ContainerOption{Name: "ssh-public-keys", Value: strings.Join(sshKeys, "\n")})
 		}
 
-		createTagsContainerOption(
-			fmt.Sprintf("size=%s", request.Size),
-			fmt.Sprintf("recurser=%s", "TODO"),
-		)
+		nextID, ok := api.reserveInstance(ctx, w, authCtx, targetNodeName, image.OSTemplate, size, expires, request)
+		if !ok {
+			return
+		}
 
-		_, err = node.NewContainer(ctx, nextID, containerOptions...)
+		createTask, err := node.NewContainer(ctx, nextID, containerOptions...)
 		if err != nil {
+			api.forgetFailedInstance(ctx, uint64(nextID))
 			writeError(w, http.StatusInternalServerError,
 				fmt.Sprintf("could not create new container: %v", err))
 			return
 		}
 
-		writeResponse(w, http.StatusCreated, CreateInstanceResponse{})
+		task, err := api.startTask(authCtx, uint64(nextID), "create", func(ctx context.Context, t *taskRun) error {
+			err := t.wait(ctx, createTask.UPID)
+			if err != nil {
+				api.cleanUpFailedInstance(ctx, t, uint64(nextID), err)
+				return fmt.Errorf("could not create container: %w", err)
+			}
+
+			return nil
+		})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not track container creation: %v", err))
+			return
+		}
+
+		api.writeCreatedInstance(w, r, task, Instance{
+			ID:       uint64(nextID),
+			Kind:     InstanceTypeContainer,
+			Size:     request.Size,
+			Name:     request.Name,
+			Node:     targetNodeName,
+			Status:   instanceStatusCreating,
+			Recurser: authCtx.RecurserID,
+		})
 		return
 	case InstanceTypeVM:
-		writeError(w, http.StatusNotImplemented, "VMs are not currently supported")
+		nextID, ok := api.reserveInstance(ctx, w, authCtx, targetNodeName, strconv.Itoa(image.VMTemplateID), size,
+			expires, request)
+		if !ok {
+			return
+		}
+
+		cloneUPID, err := api.createVM(ctx, nextID, targetNodeName, image, request)
+		if err != nil {
+			api.forgetFailedInstance(ctx, uint64(nextID))
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not create new vm: %v", err))
+			return
+		}
+
+		// Cloning a full disk can take minutes so the rest of the setup (sizing, cloud-init and first boot) happens
+		// in the background.
+		task, err := api.startTask(authCtx, uint64(nextID), "create", func(ctx context.Context, t *taskRun) error {
+			err := api.provisionVM(ctx, t, cloneUPID, targetNodeName, nextID, authCtx, size, image,
+				sshKeys, request)
+			if err != nil {
+				api.cleanUpFailedInstance(ctx, t, uint64(nextID), err)
+				return err
+			}
+
+			return nil
+		})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not track vm creation: %v", err))
+			return
+		}
+
+		api.writeCreatedInstance(w, r, task, Instance{
+			ID:       uint64(nextID),
+			Kind:     InstanceTypeVM,
+			Size:     request.Size,
+			Name:     request.Name,
+			Node:     targetNodeName,
+			Status:   instanceStatusCreating,
+			Recurser: authCtx.RecurserID,
+		})
 		return
 	default:
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("invalid instance type: %v", err))
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid instance type %q; must be %q or %q",
+			request.InstanceType, InstanceTypeContainer, InstanceTypeVM))
 		return
 	}
 }
 
-func (api *APIContext) deleteInstance(w http.ResponseWriter, r *http.Request) {
-	// TODO(): We need to check permissions here. Does the current user own the instance?
+var errInstanceNotFound = errors.New("instance not found")
 
-	ctx := context.Background()
+// Returns the path for an instance's endpoints in the Proxmox API (ex. /nodes/pve/lxc/100).
+func instancePath(resource *proxmox.ClusterResource) string {
+	return fmt.Sprintf("/nodes/%s/%s/%d", resource.Node, resource.Type, resource.VMID)
+}
+
+// Locates an instance anywhere in the cluster. Returns errInstanceNotFound if no node hosts an instance with that ID.
+func (api *APIContext) findInstance(ctx context.Context, id uint64) (*proxmox.ClusterResource, error) {
+	cluster, err := api.Client.Cluster(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get cluster: %w", err)
+	}
+
+	resources, err := cluster.Resources(ctx, "vm")
+	if err != nil {
+		return nil, fmt.Errorf("could not query cluster resources: %w", err)
+	}
+
+	for _, resource := range resources {
+		if resource.VMID == id && (resource.Type == "lxc" || resource.Type == "qemu") {
+			return resource, nil
+		}
+	}
+
+	return nil, errInstanceNotFound
+}
+
+// Reports whether the recurser is allowed to manage the instance. Admins can manage everything; everyone else can
+// only manage instances that RC3's record (or failing that, the instance's tags) says are theirs.
+func (api *APIContext) canManageInstance(authCtx AuthContext, resource *proxmox.ClusterResource) (bool, error) {
+	if authCtx.IsAdmin {
+		return true, nil
+	}
 
+	record, err := api.DB.GetInstance(resource.VMID)
+	if err != nil && !errors.Is(err, storage.ErrEntityNotFound) {
+		return false, err
+	}
+
+	if record == nil {
+		record = &storage.Instance{}
+	}
+
+	_, owner := instanceMetadata(resource.Tags, *record)
+
+	return owner != "" && owner == authCtx.RecurserID, nil
+}
+
+// Parses the instance ID from the URL and finds it in the cluster. If anything goes wrong the appropriate error is
+// written and nil is returned.
+func (api *APIContext) resolveInstance(
+	ctx context.Context, w http.ResponseWriter, r *http.Request,
+) *proxmox.ClusterResource {
 	idStr := chi.URLParam(r, "id")
-	if idStr == "" {
-		writeError(w, http.StatusInternalServerError, "received empty identifier in path")
-		return
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid instance id %q; must be a number", idStr))
+		return nil
 	}
 
-	id, err := strconv.Atoi(idStr)
+	resource, err := api.findInstance(ctx, id)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "could not ")
-		return
+		if errors.Is(err, errInstanceNotFound) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("instance %d not found", id))
+			return nil
+		}
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not look up instance %d: %v", id, err))
+		return nil
 	}
 
-	nodes, err := api.Client.Nodes(ctx)
+	return resource
+}
+
+// Like resolveInstance but also makes sure the caller is allowed to manage the instance.
+func (api *APIContext) resolveManagedInstance(
+	ctx context.Context, w http.ResponseWriter, r *http.Request,
+) *proxmox.ClusterResource {
+	resource := api.resolveInstance(ctx, w, r)
+	if resource == nil {
+		return nil
+	}
+
+	allowed, err := api.canManageInstance(CheckAuth(r), resource)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError,
-			fmt.Sprintf("could not query for nodes while attempting to get instances: %v", err))
-		return
+			fmt.Sprintf("could not check ownership of instance %d: %v", resource.VMID, err))
+		return nil
 	}
 
-	if len(nodes) == 0 {
-		writeError(w, http.StatusInternalServerError,
-			fmt.Sprintf("received no proxmox nodes while attempting to create instance", err))
-		return
+	if !allowed {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("instance %d does not belong to you", resource.VMID))
+		return nil
+	}
+
+	return resource
+}
+
+// Stops (if needed) and destroys an instance along with all of its volumes, then removes RC3's record of it.
+func (api *APIContext) destroyInstance(ctx context.Context, t *taskRun, resource *proxmox.ClusterResource) error {
+	if resource.Status == "running" {
+		var upid proxmox.UPID
+		err := api.Client.Post(ctx, instancePath(resource)+"/status/stop", nil, &upid)
+		if err != nil {
+			return fmt.Errorf("could not stop instance: %w", err)
+		}
 
+		err = t.wait(ctx, upid)
+		if err != nil {
+			return fmt.Errorf("could not stop instance: %w", err)
+		}
 	}
 
-	// We default to the first node that we find since that will work for the single proxmox instance we have.
-	// If we ever expand the proxmox cluster then we'll have to change this logic.
-	targetNodeName := nodes[0].Node
+	// Purging removes the instance from backup jobs, replication and HA; destroying unreferenced disks makes sure we
+	// don't leave orphaned volumes around.
+	var upid proxmox.UPID
+	err := api.Client.Delete(ctx, instancePath(resource)+"?purge=1&destroy-unreferenced-disks=1", &upid)
+	if err != nil {
+		return fmt.Errorf("could not destroy instance: %w", err)
+	}
 
-	node, err := api.Client.Node(ctx, targetNodeName)
+	err = t.wait(ctx, upid)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError,
-			fmt.Sprintf("could not get target node %s: %v", targetNodeName, err))
+		return fmt.Errorf("could not destroy instance: %w", err)
+	}
+
+	// Proxmox hands the ID out again, so the addresses must not carry over to the next instance that gets it.
+	api.addresses.forget(resource.VMID)
+
+	// Proxmox removes an instance's snapshots along with it.
+	err = api.DB.DeleteInstanceSnapshots(resource.VMID)
+	if err != nil {
+		log.Error().Err(err).Uint64("id", resource.VMID).Msg("could not remove snapshot records of deleted instance")
+	}
+
+	// Backups are kept so the instance can be restored later but there is nothing left to back up on a schedule.
+	err = api.DB.DeleteBackupPolicy(resource.VMID)
+	if err != nil && !errors.Is(err, storage.ErrEntityNotFound) {
+		log.Error().Err(err).Uint64("id", resource.VMID).Msg("could not remove backup policy of deleted instance")
+	}
+
+	err = api.DB.DeleteInstance(resource.VMID)
+	if err != nil && !errors.Is(err, storage.ErrEntityNotFound) {
+		return fmt.Errorf("instance was destroyed but its record could not be removed: %w", err)
+	}
+
+	return nil
+}
+
+type DeleteInstanceResponse struct {
+	Task Task `json:"task"`
+}
+
+func (api *APIContext) deleteInstance(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	resource := api.resolveManagedInstance(ctx, w, r)
+	if resource == nil {
 		return
 	}
 
-	// TODO(): WE need to go get the details of the instance here to see who owns it.
+	// Stopping and destroying can take a while so we do it in the background and let the caller know we've
+	// accepted the request.
+	task, err := api.startTask(CheckAuth(r), resource.VMID, "delete", func(ctx context.Context, t *taskRun) error {
+		return api.destroyInstance(ctx, t, resource)
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not start deleting instance: %v", err))
+		return
+	}
 
-	_ = node
-	_ = id
-	// node.Container(ctx, strconv.Atoi(id))
+	api.writeTaskResponse(w, r, task, http.StatusOK, func(task Task) any {
+		return DeleteInstanceResponse{Task: task}
+	})
 }
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/clintjedwards/rc3/internal/storage"
+)
+
+// An instance whose Proxmox task is still running must be left alone. The API has no Proxmox client so any attempt to
+// look the instance up or destroy it would panic.
+func TestCleanUpUnfinishedInstance(t *testing.T) {
+	db, err := storage.New(t.TempDir() + "/rc3.db")
+	if err != nil {
+		t.Fatalf("could not open database: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Migrate()
+	if err != nil {
+		t.Fatalf("could not migrate database: %v", err)
+	}
+
+	err = db.InsertInstance(&storage.Instance{ID: 100, Name: "cloning"})
+	if err != nil {
+		t.Fatalf("could not insert instance: %v", err)
+	}
+
+	api := &APIContext{DB: db}
+	run := &taskRun{api: api, record: &storage.Task{ID: "task"}}
+	cause := fmt.Errorf("clone did not complete: %w", errTaskUnfinished)
+
+	api.cleanUpFailedInstance(context.Background(), run, 100, cause)
+
+	_, err = db.GetInstance(100)
+	if err != nil {
+		t.Errorf("got error %v looking up instance; want its record kept", err)
+	}
+}
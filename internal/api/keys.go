@@ -0,0 +1,400 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/clintjedwards/rc3/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/ssh"
+)
+
+const maxSSHKeyNameLength = 64
+
+// RSA keys shorter than this are considered too weak to accept.
+const minRSAKeyBits = 2048
+
+// How long we'll wait on GitHub when importing keys.
+const githubImportTimeout = 10 * time.Second
+
+// GitHub usernames are alphanumeric with single dashes in between and at most 39 characters.
+var githubUsernameRegex = regexp.MustCompile(`^[a-zA-Z0-9](?:[a-zA-Z0-9]|-[a-zA-Z0-9]){0,38}$`)
+
+func (api *APIContext) sshKeysRouter() RouteEntry {
+	router := func(router chi.Router) {
+		router.Get("/", api.listSSHKeys)
+		router.Post("/", api.createSSHKey)
+		router.Post("/import", api.importSSHKeys)
+		router.Delete("/{id}", api.deleteSSHKey)
+	}
+
+	return RouteEntry{
+		Pattern: "/keys",
+		Router:  router,
+	}
+}
+
+// SSHKey is a public key that is put on every instance its owner creates.
+type SSHKey struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	PublicKey   string `json:"public_key"`
+	Fingerprint string `json:"fingerprint"` // ex. "SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8"
+	Created     int64  `json:"created"`     // Unix seconds
+}
+
+func newSSHKeyFromStorage(key *storage.SSHKey) SSHKey {
+	return SSHKey{
+		ID:          key.ID,
+		Name:        key.Name,
+		PublicKey:   key.PublicKey,
+		Fingerprint: key.Fingerprint,
+		Created:     key.Created,
+	}
+}
+
+// A public key that has been checked and normalized.
+type parsedSSHKey struct {
+	publicKey   string // In authorized_keys format, without any options.
+	fingerprint string
+	comment     string
+}
+
+// Parses a single public key in authorized_keys format (ex. the contents of ~/.ssh/id_ed25519.pub), rejecting
+// anything that isn't a usable key.
+func parseSSHKey(line string) (parsedSSHKey, error) {
+	publicKey, comment, _, rest, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return parsedSSHKey{}, fmt.Errorf("not a valid public key: %w", err)
+	}
+
+	if strings.TrimSpace(string(rest)) != "" {
+		return parsedSSHKey{}, fmt.Errorf("only one public key can be given at a time")
+	}
+
+	if publicKey.Type() == ssh.KeyAlgoDSA {
+		return parsedSSHKey{}, fmt.Errorf("DSA keys are no longer supported by OpenSSH; use an ed25519 key instead")
+	}
+
+	if cryptoKey, ok := publicKey.(ssh.CryptoPublicKey); ok {
+		if rsaKey, ok := cryptoKey.CryptoPublicKey().(*rsa.PublicKey); ok && rsaKey.N.BitLen() < minRSAKeyBits {
+			return parsedSSHKey{}, fmt.Errorf("RSA keys must be at least %d bits", minRSAKeyBits)
+		}
+	}
+
+	normalized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey)))
+	if comment != "" {
+		normalized += " " + comment
+	}
+
+	return parsedSSHKey{
+		publicKey:   normalized,
+		fingerprint: ssh.FingerprintSHA256(publicKey),
+		comment:     comment,
+	}, nil
+}
+
+func validateSSHKeyName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("key name cannot be empty")
+	}
+
+	if len(name) > maxSSHKeyNameLength {
+		return fmt.Errorf("key name cannot be longer than %d characters", maxSSHKeyNameLength)
+	}
+
+	return nil
+}
+
+// Stores a new key for the recurser. Returns storage.ErrEntityExists if they've already registered it.
+func (api *APIContext) addSSHKey(authCtx AuthContext, name string, key parsedSSHKey) (*storage.SSHKey, error) {
+	id, err := randomString(9)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate key id: %w", err)
+	}
+
+	record := &storage.SSHKey{
+		ID:          id,
+		RecurserID:  authCtx.RecurserID,
+		Name:        name,
+		PublicKey:   key.publicKey,
+		Fingerprint: key.fingerprint,
+		Created:     time.Now().Unix(),
+	}
+
+	err = api.DB.InsertSSHKey(record)
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// Returns the public keys that should be put on a new instance: every key the recurser has registered plus any
+// extra ones included in the request, without duplicates.
+func (api *APIContext) instanceSSHKeys(authCtx AuthContext, extraKeys []string) ([]string, error) {
+	records, err := api.DB.ListSSHKeys(authCtx.RecurserID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get registered keys: %w", err)
+	}
+
+	keys := []string{}
+	fingerprints := []string{}
+	for _, record := range records {
+		keys = append(keys, record.PublicKey)
+		fingerprints = append(fingerprints, record.Fingerprint)
+	}
+
+	for _, extraKey := range extraKeys {
+		key, err := parseSSHKey(extraKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ssh key %q: %w", extraKey, err)
+		}
+
+		if slices.Contains(fingerprints, key.fingerprint) {
+			continue
+		}
+
+		keys = append(keys, key.publicKey)
+		fingerprints = append(fingerprints, key.fingerprint)
+	}
+
+	return keys, nil
+}
+
+type ListSSHKeysResponse struct {
+	Keys []SSHKey `json:"keys"`
+}
+
+func (api *APIContext) listSSHKeys(w http.ResponseWriter, r *http.Request) {
+	authCtx := CheckAuth(r)
+
+	keys, err := api.DB.ListSSHKeys(authCtx.RecurserID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not list keys: %v", err))
+		return
+	}
+
+	returnedKeys := []SSHKey{}
+	for _, key := range keys {
+		returnedKeys = append(returnedKeys, newSSHKeyFromStorage(&key))
+	}
+
+	writeResponse(w, http.StatusOK, ListSSHKeysResponse{
+		Keys: returnedKeys,
+	})
+}
+
+type CreateSSHKeyRequest struct {
+	// Defaults to the key's comment (usually user@host).
+	Name string `json:"name,omitempty"`
+
+	// The public key in authorized_keys format (ex. the contents of ~/.ssh/id_ed25519.pub).
+	PublicKey string `json:"public_key"`
+
+	// If given, the key is only accepted if its SHA256 fingerprint matches. Guards against a key being mangled on its
+	// way to RC3.
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+type CreateSSHKeyResponse struct {
+	Key SSHKey `json:"key"`
+}
+
+// Checks the recurser has room for more keys, writing the appropriate error and returning the number of keys they
+// can still add.
+func (api *APIContext) remainingSSHKeys(w http.ResponseWriter, authCtx AuthContext) (int, bool) {
+	existing, err := api.DB.ListSSHKeys(authCtx.RecurserID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not list keys: %v", err))
+		return 0, false
+	}
+
+	remaining := api.SSHKeysConfig.MaxPerRecurser - len(existing)
+	if remaining <= 0 {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("you can register at most %d keys; delete one first",
+			api.SSHKeysConfig.MaxPerRecurser))
+		return 0, false
+	}
+
+	return remaining, true
+}
+
+func (api *APIContext) createSSHKey(w http.ResponseWriter, r *http.Request) {
+	authCtx := CheckAuth(r)
+
+	var request CreateSSHKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	key, err := parseSSHKey(request.PublicKey)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if request.Fingerprint != "" && request.Fingerprint != key.fingerprint {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("key fingerprint is %s, not %s", key.fingerprint,
+			request.Fingerprint))
+		return
+	}
+
+	name := request.Name
+	if name == "" {
+		name = key.comment
+	}
+	if name == "" {
+		name = key.fingerprint
+	}
+
+	if err := validateSSHKeyName(name); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, ok := api.remainingSSHKeys(w, authCtx); !ok {
+		return
+	}
+
+	record, err := api.addSSHKey(authCtx, name, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrEntityExists) {
+			writeError(w, http.StatusConflict, fmt.Sprintf("key %s is already registered", key.fingerprint))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not add key: %v", err))
+		return
+	}
+
+	writeResponse(w, http.StatusCreated, CreateSSHKeyResponse{
+		Key: newSSHKeyFromStorage(record),
+	})
+}
+
+// Fetches the public keys GitHub has for a user, one per line.
+func (api *APIContext) fetchGitHubKeys(ctx context.Context, username string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, githubImportTimeout)
+	defer cancel()
+
+	url := fmt.Sprintf("%s/%s.keys", strings.TrimSuffix(api.SSHKeysConfig.GitHubURL, "/"), username)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("GitHub user %q not found", username)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GitHub returned %s", resp.Status)
+	}
+
+	keys := []string{}
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, 1024*1024))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			keys = append(keys, line)
+		}
+	}
+
+	return keys, scanner.Err()
+}
+
+type ImportSSHKeysRequest struct {
+	GitHubUsername string `json:"github_username"`
+}
+
+type ImportSSHKeysResponse struct {
+	// The keys that were added. Keys that were already registered (or that RC3 doesn't accept) are skipped.
+	Keys []SSHKey `json:"keys"`
+}
+
+func (api *APIContext) importSSHKeys(w http.ResponseWriter, r *http.Request) {
+	authCtx := CheckAuth(r)
+
+	var request ImportSSHKeysRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	if !githubUsernameRegex.MatchString(request.GitHubUsername) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid GitHub username %q", request.GitHubUsername))
+		return
+	}
+
+	remaining, ok := api.remainingSSHKeys(w, authCtx)
+	if !ok {
+		return
+	}
+
+	lines, err := api.fetchGitHubKeys(r.Context(), request.GitHubUsername)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Sprintf("could not get keys from GitHub: %v", err))
+		return
+	}
+
+	added := []SSHKey{}
+	for _, line := range lines {
+		if len(added) >= remaining {
+			break
+		}
+
+		key, err := parseSSHKey(line)
+		if err != nil {
+			continue
+		}
+
+		record, err := api.addSSHKey(authCtx, "github/"+request.GitHubUsername, key)
+		if err != nil {
+			if errors.Is(err, storage.ErrEntityExists) {
+				continue
+			}
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not add key: %v", err))
+			return
+		}
+
+		added = append(added, newSSHKeyFromStorage(record))
+	}
+
+	writeResponse(w, http.StatusCreated, ImportSSHKeysResponse{
+		Keys: added,
+	})
+}
+
+func (api *APIContext) deleteSSHKey(w http.ResponseWriter, r *http.Request) {
+	authCtx := CheckAuth(r)
+	id := chi.URLParam(r, "id")
+
+	err := api.DB.DeleteSSHKey(authCtx.RecurserID, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrEntityNotFound) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("key %q not found", id))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not delete key: %v", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
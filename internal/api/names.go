@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"regexp"
+
+	"github.com/clintjedwards/rc3/internal/storage"
+)
+
+// Instance names double as hostnames so they have to be valid DNS labels.
+const maxInstanceNameLength = 63
+
+var instanceNameRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// How many generated names we try before giving up and tacking on a number.
+const maxNameAttempts = 10
+
+var nameAdjectives = []string{
+	"amber", "bold", "brave", "bright", "calm", "clever", "cosmic", "crisp", "curious", "daring",
+	"eager", "electric", "fancy", "fearless", "fuzzy", "gentle", "glowing", "happy", "hidden", "humble",
+	"jolly", "keen", "kind", "lively", "lucky", "mellow", "merry", "mighty", "misty", "nimble",
+	"noble", "patient", "plucky", "polite", "quick", "quiet", "rapid", "rusty", "shiny", "silent",
+	"snappy", "sparkly", "speedy", "steady", "sunny", "swift", "tidy", "vivid", "witty", "zesty",
+}
+
+var nameNouns = []string{
+	"badger", "beacon", "bison", "canyon", "comet", "coral", "crane", "falcon", "fern", "ferret",
+	"fjord", "galaxy", "gecko", "glacier", "harbor", "hedgehog", "heron", "iguana", "island", "jaguar",
+	"kestrel", "koala", "lagoon", "lantern", "lemur", "lynx", "meadow", "meteor", "narwhal", "nebula",
+	"otter", "panda", "pebble", "penguin", "pine", "puffin", "quasar", "raven", "reef", "river",
+	"salmon", "sparrow", "summit", "tapir", "tiger", "tundra", "walrus", "willow", "wombat", "yak",
+}
+
+func validateInstanceName(name string) error {
+	if len(name) > maxInstanceNameLength {
+		return fmt.Errorf("name must be at most %d characters", maxInstanceNameLength)
+	}
+
+	if !instanceNameRegex.MatchString(name) {
+		return fmt.Errorf("name must only contain lowercase letters, numbers and dashes, and must start and end " +
+			"with a letter or number")
+	}
+
+	return nil
+}
+
+func randomInstanceName() string {
+	return nameAdjectives[rand.IntN(len(nameAdjectives))] + "-" + nameNouns[rand.IntN(len(nameNouns))]
+}
+
+// Reports whether any instance in the cluster, or any instance RC3 is in the middle of creating, already has the
+// name.
+func (api *APIContext) instanceNameTaken(ctx context.Context, name string) (bool, error) {
+	_, err := api.DB.GetInstanceByName(name)
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, storage.ErrEntityNotFound) {
+		return false, err
+	}
+
+	cluster, err := api.Client.Cluster(ctx)
+	if err != nil {
+		return false, fmt.Errorf("could not get cluster: %w", err)
+	}
+
+	resources, err := cluster.Resources(ctx, "vm")
+	if err != nil {
+		return false, fmt.Errorf("could not query cluster resources: %w", err)
+	}
+
+	for _, resource := range resources {
+		if resource.Name == name {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Comes up with a friendly name (ex. "plucky-otter") that isn't already in use.
+func (api *APIContext) generateInstanceName(ctx context.Context) (string, error) {
+	for range maxNameAttempts {
+		name := randomInstanceName()
+
+		taken, err := api.instanceNameTaken(ctx, name)
+		if err != nil {
+			return "", err
+		}
+
+		if !taken {
+			return name, nil
+		}
+	}
+
+	// The odds of getting here are slim but if we do, a number makes a collision even less likely. Anything that
+	// slips through is still caught when the instance is recorded.
+	return fmt.Sprintf("%s-%d", randomInstanceName(), rand.IntN(1000)), nil
+}
+
+// Works out the name of a new instance: the requested name if it is valid and free, otherwise a generated one when
+// no name was requested. Writes the appropriate error and returns false if the requested name can't be used.
+func (api *APIContext) chooseInstanceName(ctx context.Context, w http.ResponseWriter, requested string) (string, bool) {
+	if requested == "" {
+		name, err := api.generateInstanceName(ctx)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not generate instance name: %v", err))
+			return "", false
+		}
+		return name, true
+	}
+
+	if err := validateInstanceName(requested); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid name: %v", err))
+		return "", false
+	}
+
+	taken, err := api.instanceNameTaken(ctx, requested)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not check instance name: %v", err))
+		return "", false
+	}
+
+	if taken {
+		writeError(w, http.StatusConflict, fmt.Sprintf("an instance named %q already exists", requested))
+		return "", false
+	}
+
+	return requested, true
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/clintjedwards/rc3/internal/conf"
+	"github.com/clintjedwards/rc3/internal/placement"
+	"github.com/luthermonson/go-proxmox"
+	"github.com/rs/zerolog/log"
+)
+
+// Gathers what the placement strategy needs to know about every node in the cluster.
+func (api *APIContext) placementNodes(ctx context.Context) ([]placement.Node, error) {
+	nodeStatuses, err := api.Client.Nodes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not query for nodes: %w", err)
+	}
+
+	cluster, err := api.Client.Cluster(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get cluster: %w", err)
+	}
+
+	resources, err := cluster.Resources(ctx, "vm")
+	if err != nil {
+		return nil, fmt.Errorf("could not query cluster resources: %w", err)
+	}
+
+	instanceCounts := map[string]int{}
+	for _, resource := range resources {
+		if resource.Template == 1 {
+			continue
+		}
+		instanceCounts[resource.Node]++
+	}
+
+	nodes := []placement.Node{}
+	for _, status := range nodeStatuses {
+		node := placement.Node{
+			Name:        status.Node,
+			Online:      status.Status == "online",
+			Maintenance: slices.Contains(api.PlacementConfig.MaintenanceNodes, status.Node),
+			CPU:         status.CPU,
+			MemoryUsed:  status.Mem,
+			MemoryTotal: status.MaxMem,
+			Instances:   instanceCounts[status.Node],
+		}
+
+		// Offline nodes can't tell us about their storage and won't be picked anyway.
+		if node.Online {
+			var storage proxmox.Storage
+			err := api.Client.Get(ctx,
+				fmt.Sprintf("/nodes/%s/storage/%s/status", status.Node, api.ProxmoxConfig.InstanceStorage), &storage)
+			if err != nil {
+				// A node without the instance storage can't take instances so we leave its storage at zero rather
+				// than failing placement altogether.
+				log.Warn().Err(err).Str("node", status.Node).Str("storage", api.ProxmoxConfig.InstanceStorage).
+					Msg("could not get storage status for node; it will not receive new instances")
+			} else {
+				node.StorageUsed = storage.Used
+				node.StorageTotal = storage.Total
+			}
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
+
+// Chooses the node a new instance of the given size should be created on.
+func (api *APIContext) placeInstance(ctx context.Context, size conf.Size) (string, error) {
+	node, err := api.placeWith(ctx, api.placementStrategy, size)
+	if err != nil {
+		return "", err
+	}
+
+	log.Debug().Str("node", node).Str("strategy", api.PlacementConfig.Strategy).Msg("placed new instance")
+
+	return node, nil
+}
+
+// Makes sure a particular node can take a new instance of the given size, for instances that can't go anywhere else
+// (ex. linked clones, which share disks with the instance they were cloned from).
+func (api *APIContext) placeInstanceOn(ctx context.Context, size conf.Size, node string) (string, error) {
+	return api.placeWith(ctx, placement.Pinned{Node: node}, size)
+}
+
+func (api *APIContext) placeWith(ctx context.Context, strategy placement.Strategy, size conf.Size) (string, error) {
+	nodes, err := api.placementNodes(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	node, err := placement.Place(strategy, nodes, placement.Requirements{
+		MemoryBytes: uint64(size.Memory) * 1024 * 1024,
+		DiskBytes:   uint64(size.Disk) * 1024 * 1024 * 1024,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return node.Name, nil
+}
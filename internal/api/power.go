@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/luthermonson/go-proxmox"
+	"github.com/rs/zerolog/log"
+)
+
+// PowerAction is something that changes whether an instance is running.
+type PowerAction string
+
+const (
+	PowerActionStart    PowerAction = "start"
+	PowerActionShutdown PowerAction = "shutdown" // Asks the guest OS to shut down cleanly.
+	PowerActionStop     PowerAction = "stop"     // Immediately stops the instance, like pulling the power cord.
+	PowerActionReboot   PowerAction = "reboot"
+	PowerActionSuspend  PowerAction = "suspend" // Freezes the instance in memory.
+	PowerActionResume   PowerAction = "resume"
+)
+
+// Every power action maps onto the Proxmox endpoint of the same name under /status for both containers and VMs.
+var powerActions = []PowerAction{
+	PowerActionStart,
+	PowerActionShutdown,
+	PowerActionStop,
+	PowerActionReboot,
+	PowerActionSuspend,
+	PowerActionResume,
+}
+
+type PowerActionRequest struct {
+	// Only used by shutdown. How long in seconds to wait for the guest to shut down before giving up. Leaving this
+	// unset uses Proxmox's default.
+	Timeout uint `json:"timeout,omitempty"`
+
+	// Only used by shutdown. Hard stop the instance if it hasn't shut down once the timeout is reached.
+	Force bool `json:"force,omitempty"`
+}
+
+type PowerActionResponse struct {
+	Task Task `json:"task"`
+}
+
+// Returns why an action can't be performed on an instance in its current state or an empty string if it can.
+func powerActionConflict(action PowerAction, status string) string {
+	switch action {
+	case PowerActionStart:
+		if status == "running" {
+			return "is already running"
+		}
+	case PowerActionShutdown, PowerActionStop, PowerActionReboot, PowerActionSuspend:
+		if status == "stopped" {
+			return "is not running"
+		}
+	}
+
+	return ""
+}
+
+// Asks Proxmox to perform a power action on an instance and returns the task carrying it out.
+func (api *APIContext) performPowerAction(
+	ctx context.Context, resource *proxmox.ClusterResource, action PowerAction, request PowerActionRequest,
+) (proxmox.UPID, error) {
+	params := map[string]any{}
+	if action == PowerActionShutdown {
+		if request.Timeout > 0 {
+			params["timeout"] = request.Timeout
+		}
+		if request.Force {
+			params["forceStop"] = 1
+		}
+	}
+
+	var upid proxmox.UPID
+	err := api.Client.Post(ctx, fmt.Sprintf("%s/status/%s", instancePath(resource), action), params, &upid)
+	if err != nil {
+		return "", err
+	}
+
+	// Whatever addresses we remember may not survive the instance changing state.
+	api.addresses.forget(resource.VMID)
+
+	return upid, nil
+}
+
+// Returns a handler which performs the given power action on the instance in the URL.
+func (api *APIContext) powerActionHandler(action PowerAction) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.Background()
+
+		// The request body is optional since most actions don't take any settings.
+		var request PowerActionRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+			return
+		}
+
+		resource := api.resolveManagedInstance(ctx, w, r)
+		if resource == nil {
+			return
+		}
+
+		if conflict := powerActionConflict(action, resource.Status); conflict != "" {
+			writeError(w, http.StatusConflict, fmt.Sprintf("instance %d %s", resource.VMID, conflict))
+			return
+		}
+
+		upid, err := api.performPowerAction(ctx, resource, action, request)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError,
+				fmt.Sprintf("could not %s instance %d: %v", action, resource.VMID, err))
+			return
+		}
+
+		authCtx := CheckAuth(r)
+
+		log.Info().Uint64("id", resource.VMID).Str("node", resource.Node).Str("action", string(action)).
+			Str("recurser_id", authCtx.RecurserID).Msg("performing power action on instance")
+
+		task, err := api.startTask(authCtx, resource.VMID, string(action), func(ctx context.Context, t *taskRun) error {
+			return t.wait(ctx, upid)
+		})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError,
+				fmt.Sprintf("could not track %s of instance %d: %v", action, resource.VMID, err))
+			return
+		}
+
+		api.writeTaskResponse(w, r, task, http.StatusOK, func(task Task) any {
+			return PowerActionResponse{Task: task}
+		})
+	}
+}
@@ -0,0 +1,462 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/clintjedwards/rc3/internal/conf"
+	"github.com/clintjedwards/rc3/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+)
+
+func (api *APIContext) meRouter() RouteEntry {
+	router := func(router chi.Router) {
+		router.Get("/", api.getMe)
+		router.Get("/quota", api.getMyQuota)
+		router.Get("/events", api.listMyEvents)
+	}
+
+	return RouteEntry{
+		Pattern: "/me",
+		Router:  router,
+	}
+}
+
+func (api *APIContext) quotasRouter() RouteEntry {
+	router := func(router chi.Router) {
+		router.Get("/", api.listQuotas)
+		router.Get("/{recurser_id}", api.getQuota)
+		router.Put("/{recurser_id}", api.setQuota)
+		router.Delete("/{recurser_id}", api.resetQuota)
+	}
+
+	return RouteEntry{
+		Pattern: "/quotas",
+		Router:  router,
+	}
+}
+
+// Quota is how much of the cluster a recurser can use. A limit of 0 means no limit.
+type Quota struct {
+	MaxInstances int `json:"max_instances"`
+	MaxCores     int `json:"max_cores"`
+	MaxMemoryMB  int `json:"max_memory_mb"`
+	MaxDiskGB    int `json:"max_disk_gb"`
+	MaxBackupGB  int `json:"max_backup_gb"`
+}
+
+// QuotaUsage is how much of their quota a recurser is currently using.
+type QuotaUsage struct {
+	Instances int `json:"instances"`
+	Cores     int `json:"cores"`
+	MemoryMB  int `json:"memory_mb"`
+	DiskGB    int `json:"disk_gb"`
+	BackupGB  int `json:"backup_gb"` // Rounded up to the nearest GB.
+}
+
+// RecurserQuota is a recurser's quota alongside how much of it they are using.
+type RecurserQuota struct {
+	RecurserID string     `json:"recurser_id"`
+	Quota      Quota      `json:"quota"`
+	Usage      QuotaUsage `json:"usage"`
+	Override   bool       `json:"override"` // Whether an admin has set this recurser's quota rather than the default.
+}
+
+func newQuotaFromConfig(quotas *conf.Quotas) Quota {
+	return Quota{
+		MaxInstances: quotas.MaxInstances,
+		MaxCores:     quotas.MaxCores,
+		MaxMemoryMB:  quotas.MaxMemory,
+		MaxDiskGB:    quotas.MaxDisk,
+		MaxBackupGB:  quotas.MaxBackup,
+	}
+}
+
+// Overrides that don't set a backup limit get the default one.
+func newQuotaFromStorage(quota *storage.Quota, defaults *conf.Quotas) Quota {
+	maxBackup := defaults.MaxBackup
+	if quota.MaxBackup != nil {
+		maxBackup = *quota.MaxBackup
+	}
+
+	return Quota{
+		MaxInstances: quota.MaxInstances,
+		MaxCores:     quota.MaxCores,
+		MaxMemoryMB:  quota.MaxMemory,
+		MaxDiskGB:    quota.MaxDisk,
+		MaxBackupGB:  maxBackup,
+	}
+}
+
+// The resources an instance of the given size takes up against its owner's quota.
+func sizeUsage(size conf.Size) QuotaUsage {
+	return QuotaUsage{
+		Instances: 1,
+		Cores:     size.Cores,
+		MemoryMB:  size.Memory,
+		DiskGB:    size.Disk,
+	}
+}
+
+func (u QuotaUsage) add(other QuotaUsage) QuotaUsage {
+	return QuotaUsage{
+		Instances: u.Instances + other.Instances,
+		Cores:     u.Cores + other.Cores,
+		MemoryMB:  u.MemoryMB + other.MemoryMB,
+		DiskGB:    u.DiskGB + other.DiskGB,
+		BackupGB:  u.BackupGB + other.BackupGB,
+	}
+}
+
+// Checks whether taking up the requested resources on top of what is already used would go over the quota. The
+// error names the limit that would be exceeded.
+func checkQuota(quota Quota, usage, requested QuotaUsage) error {
+	limits := []struct {
+		name      string
+		unit      string
+		limit     int
+		used      int
+		requested int
+	}{
+		{"instance", "instances", quota.MaxInstances, usage.Instances, requested.Instances},
+		{"core", "cores", quota.MaxCores, usage.Cores, requested.Cores},
+		{"memory", "MB of memory", quota.MaxMemoryMB, usage.MemoryMB, requested.MemoryMB},
+		{"disk", "GB of disk", quota.MaxDiskGB, usage.DiskGB, requested.DiskGB},
+	}
+
+	for _, limit := range limits {
+		if limit.limit == 0 || limit.requested == 0 || limit.used+limit.requested <= limit.limit {
+			continue
+		}
+
+		return fmt.Errorf("%s quota exceeded: this needs %d %s but you are already using %d of your %d",
+			limit.name, limit.requested, limit.unit, limit.used, limit.limit)
+	}
+
+	return nil
+}
+
+// Checks whether the recurser has room for another backup. Backups are only refused once the recurser is already at
+// their limit since how big a backup will be isn't known until it has been taken.
+func checkBackupQuota(quota Quota, usage QuotaUsage) error {
+	if quota.MaxBackupGB == 0 || usage.BackupGB < quota.MaxBackupGB {
+		return nil
+	}
+
+	return fmt.Errorf("backup quota exceeded: your backups already take up %d of your %d GB; delete some first",
+		usage.BackupGB, quota.MaxBackupGB)
+}
+
+// Returns the quota that applies to a recurser and whether it is an admin set override.
+func (api *APIContext) recurserQuota(recurserID string) (Quota, bool, error) {
+	quota, err := api.DB.GetQuota(recurserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrEntityNotFound) {
+			return newQuotaFromConfig(api.QuotasConfig), false, nil
+		}
+		return Quota{}, false, err
+	}
+
+	return newQuotaFromStorage(quota, api.QuotasConfig), true, nil
+}
+
+// Adds up the resources used by every instance a recurser owns, including ones still being created, and the space
+// taken up by their backups.
+func (api *APIContext) quotaUsage(recurserID string) (QuotaUsage, error) {
+	records, err := api.DB.ListInstancesByRecurser(recurserID)
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+
+	usage := QuotaUsage{}
+	for _, record := range records {
+		size, ok := api.findSize(record.Size)
+		if !ok {
+			// Sizes removed from the config still count as an instance; we just can't tell what else they use.
+			log.Warn().Uint64("id", record.ID).Str("size", record.Size).
+				Msg("instance has a size that is no longer defined; only counting it against the instance quota")
+			usage.Instances++
+			continue
+		}
+
+		usage = usage.add(sizeUsage(size))
+	}
+
+	backupBytes, err := api.DB.SumBackupBytes(recurserID)
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+	usage.BackupGB = int((backupBytes + bytesPerGB - 1) / bytesPerGB)
+
+	return usage, nil
+}
+
+// Makes sure the recurser has room in their quota for the requested resources and, if they do, calls claim while
+// still holding the quota lock. claim should record whatever is taking up the resources so the next check sees them;
+// checking and claiming together is what stops concurrent requests from both squeezing into the last of a quota.
+//
+// Writes the appropriate error and returns false if the quota would be exceeded or claim fails.
+func (api *APIContext) withinQuota(w http.ResponseWriter, authCtx AuthContext, requested QuotaUsage,
+	claim func() bool,
+) bool {
+	api.quotaMu.Lock()
+	defer api.quotaMu.Unlock()
+
+	quota, _, err := api.recurserQuota(authCtx.RecurserID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get quota: %v", err))
+		return false
+	}
+
+	usage, err := api.quotaUsage(authCtx.RecurserID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not work out quota usage: %v", err))
+		return false
+	}
+
+	err = checkQuota(quota, usage, requested)
+	if err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return false
+	}
+
+	return claim()
+}
+
+func (api *APIContext) describeQuota(recurserID string) (RecurserQuota, error) {
+	quota, override, err := api.recurserQuota(recurserID)
+	if err != nil {
+		return RecurserQuota{}, fmt.Errorf("could not get quota: %w", err)
+	}
+
+	usage, err := api.quotaUsage(recurserID)
+	if err != nil {
+		return RecurserQuota{}, fmt.Errorf("could not work out quota usage: %w", err)
+	}
+
+	return RecurserQuota{
+		RecurserID: recurserID,
+		Quota:      quota,
+		Usage:      usage,
+		Override:   override,
+	}, nil
+}
+
+type GetQuotaResponse struct {
+	Quota RecurserQuota `json:"quota"`
+}
+
+func (api *APIContext) getMyQuota(w http.ResponseWriter, r *http.Request) {
+	authCtx := CheckAuth(r)
+
+	quota, err := api.describeQuota(authCtx.RecurserID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeResponse(w, http.StatusOK, GetQuotaResponse{
+		Quota: quota,
+	})
+}
+
+// Writes an error and returns false if the caller isn't an admin. Only admins can see or change other recursers'
+// quotas.
+func requireAdmin(w http.ResponseWriter, authCtx AuthContext) bool {
+	if !authCtx.IsAdmin {
+		writeError(w, http.StatusForbidden, "only admins can manage quotas")
+		return false
+	}
+
+	return true
+}
+
+type ListQuotasResponse struct {
+	// Every recurser with an admin set quota. Everyone else has the default quota.
+	Quotas  []RecurserQuota `json:"quotas"`
+	Default Quota           `json:"default"`
+}
+
+func (api *APIContext) listQuotas(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, CheckAuth(r)) {
+		return
+	}
+
+	overrides, err := api.DB.ListQuotas()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not list quotas: %v", err))
+		return
+	}
+
+	quotas := []RecurserQuota{}
+	for _, override := range overrides {
+		usage, err := api.quotaUsage(override.RecurserID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not work out quota usage: %v", err))
+			return
+		}
+
+		quotas = append(quotas, RecurserQuota{
+			RecurserID: override.RecurserID,
+			Quota:      newQuotaFromStorage(&override, api.QuotasConfig),
+			Usage:      usage,
+			Override:   true,
+		})
+	}
+
+	writeResponse(w, http.StatusOK, ListQuotasResponse{
+		Quotas:  quotas,
+		Default: newQuotaFromConfig(api.QuotasConfig),
+	})
+}
+
+func (api *APIContext) getQuota(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, CheckAuth(r)) {
+		return
+	}
+
+	quota, err := api.describeQuota(chi.URLParam(r, "recurser_id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeResponse(w, http.StatusOK, GetQuotaResponse{
+		Quota: quota,
+	})
+}
+
+// Limits left out are kept as they currently are for the recurser. A backup limit that has never been set follows the
+// default.
+type SetQuotaRequest struct {
+	MaxInstances *int `json:"max_instances,omitempty"`
+	MaxCores     *int `json:"max_cores,omitempty"`
+	MaxMemoryMB  *int `json:"max_memory_mb,omitempty"`
+	MaxDiskGB    *int `json:"max_disk_gb,omitempty"`
+	MaxBackupGB  *int `json:"max_backup_gb,omitempty"`
+}
+
+func (api *APIContext) setQuota(w http.ResponseWriter, r *http.Request) {
+	authCtx := CheckAuth(r)
+	if !requireAdmin(w, authCtx) {
+		return
+	}
+
+	recurserID := chi.URLParam(r, "recurser_id")
+
+	var request SetQuotaRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	// Holding the quota lock means a create can't check against the old quota while we're changing it.
+	api.quotaMu.Lock()
+	defer api.quotaMu.Unlock()
+
+	quota, _, err := api.recurserQuota(recurserID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get quota: %v", err))
+		return
+	}
+
+	for _, limit := range []struct {
+		requested *int
+		current   *int
+	}{
+		{request.MaxInstances, &quota.MaxInstances},
+		{request.MaxCores, &quota.MaxCores},
+		{request.MaxMemoryMB, &quota.MaxMemoryMB},
+		{request.MaxDiskGB, &quota.MaxDiskGB},
+		{request.MaxBackupGB, &quota.MaxBackupGB},
+	} {
+		if limit.requested == nil {
+			continue
+		}
+
+		if *limit.requested < 0 {
+			writeError(w, http.StatusBadRequest, "quota limits can't be negative; use 0 for no limit")
+			return
+		}
+
+		*limit.current = *limit.requested
+	}
+
+	// Unlike the other limits, a backup limit that isn't given is left following the default rather than being
+	// fixed at its current value.
+	var maxBackup *int
+	existing, err := api.DB.GetQuota(recurserID)
+	if err != nil && !errors.Is(err, storage.ErrEntityNotFound) {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get quota: %v", err))
+		return
+	}
+	if existing != nil {
+		maxBackup = existing.MaxBackup
+	}
+	if request.MaxBackupGB != nil {
+		maxBackup = request.MaxBackupGB
+	}
+
+	err = api.DB.PutQuota(&storage.Quota{
+		RecurserID:   recurserID,
+		MaxInstances: quota.MaxInstances,
+		MaxCores:     quota.MaxCores,
+		MaxMemory:    quota.MaxMemoryMB,
+		MaxDisk:      quota.MaxDiskGB,
+		MaxBackup:    maxBackup,
+		Updated:      time.Now().Unix(),
+		UpdatedBy:    authCtx.RecurserID,
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not save quota: %v", err))
+		return
+	}
+
+	log.Info().Str("recurser_id", recurserID).Str("admin", authCtx.RecurserID).Interface("quota", quota).
+		Msg("quota updated")
+
+	usage, err := api.quotaUsage(recurserID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not work out quota usage: %v", err))
+		return
+	}
+
+	writeResponse(w, http.StatusOK, GetQuotaResponse{
+		Quota: RecurserQuota{
+			RecurserID: recurserID,
+			Quota:      quota,
+			Usage:      usage,
+			Override:   true,
+		},
+	})
+}
+
+// Removes an admin set quota so the recurser goes back to the default.
+func (api *APIContext) resetQuota(w http.ResponseWriter, r *http.Request) {
+	authCtx := CheckAuth(r)
+	if !requireAdmin(w, authCtx) {
+		return
+	}
+
+	recurserID := chi.URLParam(r, "recurser_id")
+
+	api.quotaMu.Lock()
+	err := api.DB.DeleteQuota(recurserID)
+	api.quotaMu.Unlock()
+	if err != nil {
+		if errors.Is(err, storage.ErrEntityNotFound) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("recurser %s already has the default quota", recurserID))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not reset quota: %v", err))
+		return
+	}
+
+	log.Info().Str("recurser_id", recurserID).Str("admin", authCtx.RecurserID).Msg("quota reset to default")
+
+	w.WriteHeader(http.StatusNoContent)
+}
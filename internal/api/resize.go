@@ -0,0 +1,270 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/clintjedwards/rc3/internal/conf"
+	"github.com/clintjedwards/rc3/internal/storage"
+	"github.com/luthermonson/go-proxmox"
+	"github.com/rs/zerolog/log"
+)
+
+type ResizeInstanceRequest struct {
+	Size InstanceSize `json:"size"`
+}
+
+type ResizeInstanceResponse struct {
+	Size InstanceSize `json:"size"`
+	Task Task         `json:"task"`
+}
+
+// A change Proxmox is holding back until the VM is restarted because it couldn't be applied while it was running.
+type vmPendingChange struct {
+	Key     string `json:"key"`
+	Pending any    `json:"pending"` // Only set when the key has a change waiting to be applied.
+}
+
+// Works out how much more of its owner's quota an instance takes up after moving from one size to another. Shrinking
+// hands resources back. Instances whose old size is no longer defined only ever counted as an instance, so the whole
+// new size is requested.
+func resizeUsage(from conf.Size, known bool, to conf.Size) QuotaUsage {
+	if !known {
+		requested := sizeUsage(to)
+		requested.Instances = 0
+		return requested
+	}
+
+	return QuotaUsage{
+		Cores:    to.Cores - from.Cores,
+		MemoryMB: to.Memory - from.Memory,
+		DiskGB:   to.Disk - from.Disk,
+	}
+}
+
+// Parses a disk size as Proxmox reports it (ex. "60G", "512M" or a plain number of bytes) into bytes.
+func parseDiskSize(size string) (uint64, error) {
+	units := map[byte]float64{
+		'K': 1 << 10,
+		'M': 1 << 20,
+		'G': 1 << 30,
+		'T': 1 << 40,
+	}
+
+	number := size
+	multiplier := 1.0
+	if size != "" {
+		if unit, ok := units[size[len(size)-1]]; ok {
+			number = size[:len(size)-1]
+			multiplier = unit
+		}
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("could not parse disk size %q", size)
+	}
+
+	return uint64(value * multiplier), nil
+}
+
+// Moves an instance to a different size. CPU and memory changes are applied straight away where Proxmox can change
+// them on a running instance and the root disk is grown to match; disks can't be shrunk so sizes with a smaller disk
+// than the instance already has are refused. The instance is counted against its owner's quota at its new size
+// before anything is changed.
+func (api *APIContext) resizeInstance(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	authCtx := CheckAuth(r)
+
+	var request ResizeInstanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	if request.Size == "" {
+		writeError(w, http.StatusBadRequest, "size is required")
+		return
+	}
+
+	resource := api.resolveManagedInstance(ctx, w, r)
+	if resource == nil {
+		return
+	}
+
+	if resource.Template == 1 {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("instance %d is a template; clone it and resize the clone "+
+			"instead", resource.VMID))
+		return
+	}
+
+	record, err := api.DB.GetInstance(resource.VMID)
+	if err != nil {
+		if errors.Is(err, storage.ErrEntityNotFound) {
+			writeError(w, http.StatusConflict, fmt.Sprintf("instance %d wasn't created by RC3 so its size isn't "+
+				"tracked and it can't be resized", resource.VMID))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get instance record: %v", err))
+		return
+	}
+
+	kind := instanceTypeFromResource(resource.Type)
+
+	size, err := api.lookupSize(request.Size, kind)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid size: %v", err))
+		return
+	}
+
+	rootDisk := "rootfs"
+	if kind == InstanceTypeVM {
+		rootDisk = api.ProxmoxConfig.VMRootDisk
+	}
+
+	config := map[string]any{}
+	err = api.Client.Get(ctx, instancePath(resource)+"/config", &config)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get instance config: %v", err))
+		return
+	}
+
+	currentDisk := propertyValue(fmt.Sprint(config[rootDisk]), "size")
+	currentDiskBytes, err := parseDiskSize(currentDisk)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not work out the size of instance %d's "+
+			"root disk: %v", resource.VMID, err))
+		return
+	}
+
+	diskBytes := uint64(size.Disk) * bytesPerGB
+	if diskBytes < currentDiskBytes {
+		writeError(w, http.StatusConflict, fmt.Sprintf("size %q has a %d GB disk but instance %d's disk is already %s "+
+			"and disks can't be shrunk; choose a size with at least as much disk", size.Name, size.Disk, resource.VMID,
+			currentDisk))
+		return
+	}
+
+	from, known := api.findSize(record.Size)
+
+	// Resizing someone else's instance (as an admin) still comes out of the owner's quota.
+	owner := AuthContext{RecurserID: record.RecurserID}
+	if !api.withinQuota(w, owner, resizeUsage(from, known, size), func() bool {
+		err := api.DB.UpdateInstanceSize(record.ID, size.Name)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not update instance: %v", err))
+			return false
+		}
+
+		return true
+	}) {
+		return
+	}
+
+	log.Info().Uint64("id", resource.VMID).Str("from", record.Size).Str("to", size.Name).
+		Str("recurser_id", authCtx.RecurserID).Msg("resizing instance")
+
+	task, err := api.startTask(authCtx, resource.VMID, "resize", func(ctx context.Context, t *taskRun) error {
+		err := api.applySize(ctx, t, resource, kind, size)
+		if err != nil {
+			// Nothing was changed so the instance goes back to counting as its old size.
+			if err := api.DB.UpdateInstanceSize(record.ID, record.Size); err != nil {
+				log.Error().Err(err).Uint64("id", record.ID).Msg("could not restore instance size after failed resize")
+			}
+			return err
+		}
+
+		if diskBytes == currentDiskBytes {
+			return nil
+		}
+
+		t.logf("growing root disk from %s to %dG", currentDisk, size.Disk)
+
+		var upid proxmox.UPID
+		err = api.Client.Put(ctx, instancePath(resource)+"/resize", map[string]string{
+			"disk": rootDisk,
+			"size": fmt.Sprintf("%dG", size.Disk),
+		}, &upid)
+		if err != nil {
+			return fmt.Errorf("could not resize root disk: %w", err)
+		}
+
+		err = t.wait(ctx, upid)
+		if err != nil {
+			return fmt.Errorf("could not resize root disk: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not track resize: %v", err))
+		return
+	}
+
+	api.writeTaskResponse(w, r, task, http.StatusOK, func(task Task) any {
+		return ResizeInstanceResponse{Size: InstanceSize(size.Name), Task: task}
+	})
+}
+
+// Applies a size's CPU and memory settings to an instance and retags it with the new size. Containers pick the
+// changes up immediately; running VMs only take the ones Proxmox can hotplug and hold the rest until they are next
+// restarted, which is noted in the task log.
+func (api *APIContext) applySize(
+	ctx context.Context, t *taskRun, resource *proxmox.ClusterResource, kind InstanceType, size conf.Size,
+) error {
+	config := map[string]any{
+		"cores":    size.Cores,
+		"cpulimit": size.CPULimit,
+		"memory":   size.Memory,
+		"tags":     setTag(resource.Tags, tagKeySize, size.Name),
+	}
+
+	if kind == InstanceTypeContainer {
+		config["swap"] = size.Swap
+
+		err := api.Client.Put(ctx, instancePath(resource)+"/config", config, nil)
+		if err != nil {
+			return fmt.Errorf("could not configure container: %w", err)
+		}
+
+		return nil
+	}
+
+	var upid proxmox.UPID
+	err := api.Client.Post(ctx, instancePath(resource)+"/config", config, &upid)
+	if err != nil {
+		return fmt.Errorf("could not configure vm: %w", err)
+	}
+
+	err = t.wait(ctx, upid)
+	if err != nil {
+		return fmt.Errorf("could not configure vm: %w", err)
+	}
+
+	var changes []vmPendingChange
+	err = api.Client.Get(ctx, instancePath(resource)+"/pending", &changes)
+	if err != nil {
+		// The new settings are saved either way; this only decides whether to warn about a restart.
+		log.Warn().Err(err).Uint64("id", resource.VMID).Msg("could not check vm for pending changes")
+		return nil
+	}
+
+	pending := []string{}
+	for _, change := range changes {
+		if change.Pending != nil && (change.Key == "cores" || change.Key == "cpulimit" || change.Key == "memory") {
+			pending = append(pending, change.Key)
+		}
+	}
+
+	if len(pending) > 0 {
+		t.logf("proxmox couldn't change %s while the vm was running; the change applies the next time it is "+
+			"restarted", strings.Join(pending, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/clintjedwards/rc3/internal/conf"
+	"github.com/go-chi/chi/v5"
+)
+
+func (api *APIContext) sizesRouter() RouteEntry {
+	router := func(router chi.Router) {
+		router.Get("/", api.listSizes)
+	}
+
+	return RouteEntry{
+		Pattern: "/sizes",
+		Router:  router,
+	}
+}
+
+// Size is an instance size recursers can choose when creating an instance.
+type Size struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Cores       int            `json:"cores"`
+	CPULimit    int            `json:"cpu_limit"` // 0 means no limit.
+	MemoryMB    int            `json:"memory_mb"`
+	SwapMB      int            `json:"swap_mb"` // Only applies to containers.
+	DiskGB      int            `json:"disk_gb"`
+	Kinds       []InstanceType `json:"kinds"` // The kinds of instance that can be this size.
+}
+
+// The kinds of instance a size can be used for. A size that doesn't list any can be used for all of them.
+func sizeKinds(size conf.Size) []InstanceType {
+	if len(size.Kinds) == 0 {
+		return []InstanceType{InstanceTypeContainer, InstanceTypeVM}
+	}
+
+	kinds := []InstanceType{}
+	for _, kind := range size.Kinds {
+		kinds = append(kinds, InstanceType(kind))
+	}
+
+	return kinds
+}
+
+func newSizeFromConfig(size conf.Size) Size {
+	return Size{
+		Name:        size.Name,
+		Description: size.Description,
+		Cores:       size.Cores,
+		CPULimit:    size.CPULimit,
+		MemoryMB:    size.Memory,
+		SwapMB:      size.Swap,
+		DiskGB:      size.Disk,
+		Kinds:       sizeKinds(size),
+	}
+}
+
+// Makes sure the size catalog in the config is usable so mistakes are caught at startup rather than when someone
+// tries to create an instance.
+func validateSizes(sizes []conf.Size) error {
+	if len(sizes) == 0 {
+		return fmt.Errorf("at least one size must be defined")
+	}
+
+	seen := map[string]bool{}
+	for _, size := range sizes {
+		if size.Name == "" {
+			return fmt.Errorf("every size must have a name")
+		}
+
+		if seen[size.Name] {
+			return fmt.Errorf("size %q is defined more than once", size.Name)
+		}
+		seen[size.Name] = true
+
+		if size.Cores <= 0 || size.Memory <= 0 || size.Disk <= 0 {
+			return fmt.Errorf("size %q must have a positive number of cores, memory and disk", size.Name)
+		}
+
+		if size.CPULimit < 0 || size.Swap < 0 {
+			return fmt.Errorf("size %q can't have a negative cpulimit or swap", size.Name)
+		}
+
+		for _, kind := range size.Kinds {
+			if kind != string(InstanceTypeContainer) && kind != string(InstanceTypeVM) {
+				return fmt.Errorf("size %q has an invalid kind %q; must be %q or %q", size.Name, kind,
+					InstanceTypeContainer, InstanceTypeVM)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Finds a size in the catalog by name, whatever kind of instance it is for.
+func (api *APIContext) findSize(name string) (conf.Size, bool) {
+	for _, size := range api.Sizes {
+		if size.Name == name {
+			return size, true
+		}
+	}
+
+	return conf.Size{}, false
+}
+
+// Looks up a size in the catalog, making sure it can be used for the kind of instance being created.
+func (api *APIContext) lookupSize(name InstanceSize, kind InstanceType) (conf.Size, error) {
+	names := []string{}
+	for _, size := range api.Sizes {
+		if size.Name != string(name) {
+			names = append(names, size.Name)
+			continue
+		}
+
+		if !slices.Contains(sizeKinds(size), kind) {
+			return conf.Size{}, fmt.Errorf("size %q is not available for %s instances", name, kind)
+		}
+
+		return size, nil
+	}
+
+	return conf.Size{}, fmt.Errorf("unknown size %q; must be one of: %s", name, strings.Join(names, ", "))
+}
+
+type ListSizesResponse struct {
+	Sizes []Size `json:"sizes"`
+}
+
+func (api *APIContext) listSizes(w http.ResponseWriter, r *http.Request) {
+	sizes := []Size{}
+	for _, size := range api.Sizes {
+		sizes = append(sizes, newSizeFromConfig(size))
+	}
+
+	writeResponse(w, http.StatusOK, ListSizesResponse{
+		Sizes: sizes,
+	})
+}
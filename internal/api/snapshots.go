@@ -0,0 +1,400 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/clintjedwards/rc3/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"github.com/luthermonson/go-proxmox"
+	"github.com/rs/zerolog/log"
+)
+
+// Proxmox snapshot names have to start with a letter and can only contain letters, numbers, dashes and underscores.
+var snapshotNameRegex = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]{1,39}$`)
+
+// Proxmox always lists the instance's live state as a snapshot with this name.
+const currentSnapshotName = "current"
+
+// Snapshot is a point in time copy of an instance that it can be rolled back to.
+type Snapshot struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Created     int64  `json:"created"`      // Unix seconds
+	Parent      string `json:"parent"`       // The snapshot this one was taken on top of, if any.
+	IncludesRAM bool   `json:"includes_ram"` // Only VMs can include RAM; rolling back to one resumes the VM.
+}
+
+// A snapshot as Proxmox lists it.
+type proxmoxSnapshot struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	SnapTime    int64  `json:"snaptime"`
+	Parent      string `json:"parent"`
+	VMState     int    `json:"vmstate"`
+}
+
+func validateSnapshotName(name string) error {
+	if name == currentSnapshotName {
+		return fmt.Errorf("%q is reserved by Proxmox", currentSnapshotName)
+	}
+
+	if !snapshotNameRegex.MatchString(name) {
+		return fmt.Errorf("snapshot names must be 2-40 characters, start with a letter and contain only letters, " +
+			"numbers, dashes and underscores")
+	}
+
+	return nil
+}
+
+// Works out who an instance's snapshots count against.
+func (api *APIContext) instanceOwner(resource *proxmox.ClusterResource) (string, error) {
+	record, err := api.DB.GetInstance(resource.VMID)
+	if err != nil && !errors.Is(err, storage.ErrEntityNotFound) {
+		return "", err
+	}
+
+	if record == nil {
+		record = &storage.Instance{}
+	}
+
+	_, owner := instanceMetadata(resource.Tags, *record)
+	return owner, nil
+}
+
+func (api *APIContext) listInstanceSnapshots(
+	ctx context.Context, resource *proxmox.ClusterResource,
+) ([]Snapshot, error) {
+	var proxmoxSnapshots []proxmoxSnapshot
+	err := api.Client.Get(ctx, instancePath(resource)+"/snapshot", &proxmoxSnapshots)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []Snapshot{}
+	for _, snapshot := range proxmoxSnapshots {
+		if snapshot.Name == currentSnapshotName {
+			continue
+		}
+
+		snapshots = append(snapshots, Snapshot{
+			Name:        snapshot.Name,
+			Description: snapshot.Description,
+			Created:     snapshot.SnapTime,
+			Parent:      snapshot.Parent,
+			IncludesRAM: snapshot.VMState == 1,
+		})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Created < snapshots[j].Created
+	})
+
+	return snapshots, nil
+}
+
+type ListSnapshotsResponse struct {
+	Snapshots []Snapshot `json:"snapshots"` // Oldest first.
+}
+
+func (api *APIContext) listSnapshots(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+
+	resource := api.resolveManagedInstance(ctx, w, r)
+	if resource == nil {
+		return
+	}
+
+	snapshots, err := api.listInstanceSnapshots(ctx, resource)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not list snapshots: %v", err))
+		return
+	}
+
+	writeResponse(w, http.StatusOK, ListSnapshotsResponse{
+		Snapshots: snapshots,
+	})
+}
+
+type CreateSnapshotRequest struct {
+	// Defaults to the time the snapshot was taken (ex. "snap-20240101-120000").
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	// Save the VM's memory too so rolling back resumes it exactly where it was. Only applies to VMs.
+	IncludeRAM bool `json:"include_ram,omitempty"`
+}
+
+type SnapshotResponse struct {
+	Snapshot string `json:"snapshot"`
+	Task     Task   `json:"task"`
+}
+
+// Checks the instance's owner has room for another snapshot and, if they do, records it so the next check counts
+// it. Writes the appropriate error and returns false otherwise.
+func (api *APIContext) reserveSnapshot(w http.ResponseWriter, instanceID uint64, name, owner string) bool {
+	api.snapshotsMu.Lock()
+	defer api.snapshotsMu.Unlock()
+
+	if limit := api.SnapshotsConfig.MaxPerRecurser; limit > 0 {
+		count, err := api.DB.CountSnapshots(owner)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not count snapshots: %v", err))
+			return false
+		}
+
+		if count >= limit {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("snapshot limit reached: instances owned by %s already "+
+				"have %d of %d snapshots; delete one first", owner, count, limit))
+			return false
+		}
+	}
+
+	err := api.DB.InsertSnapshot(&storage.Snapshot{
+		InstanceID: instanceID,
+		Name:       name,
+		RecurserID: owner,
+		Created:    time.Now().Unix(),
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrEntityExists) {
+			writeError(w, http.StatusConflict, fmt.Sprintf("instance %d already has a snapshot named %q",
+				instanceID, name))
+			return false
+		}
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not record snapshot: %v", err))
+		return false
+	}
+
+	return true
+}
+
+// Removes RC3's record of a snapshot so it no longer counts against its owner.
+func (api *APIContext) forgetSnapshot(instanceID uint64, name string) {
+	err := api.DB.DeleteSnapshot(instanceID, name)
+	if err != nil && !errors.Is(err, storage.ErrEntityNotFound) {
+		log.Error().Err(err).Uint64("instance_id", instanceID).Str("snapshot", name).
+			Msg("could not remove snapshot record")
+	}
+}
+
+func (api *APIContext) createSnapshot(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	authCtx := CheckAuth(r)
+
+	var request CreateSnapshotRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	resource := api.resolveManagedInstance(ctx, w, r)
+	if resource == nil {
+		return
+	}
+
+	if request.Name == "" {
+		request.Name = "snap-" + time.Now().UTC().Format("20060102-150405")
+	}
+
+	if err := validateSnapshotName(request.Name); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid snapshot name: %v", err))
+		return
+	}
+
+	if request.IncludeRAM && resource.Type != "qemu" {
+		writeError(w, http.StatusBadRequest, "only VM snapshots can include RAM")
+		return
+	}
+
+	owner, err := api.instanceOwner(resource)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get instance record: %v", err))
+		return
+	}
+
+	if !api.reserveSnapshot(w, resource.VMID, request.Name, owner) {
+		return
+	}
+
+	params := map[string]any{
+		"snapname":    request.Name,
+		"description": request.Description,
+	}
+	if request.IncludeRAM {
+		params["vmstate"] = 1
+	}
+
+	var upid proxmox.UPID
+	err = api.Client.Post(ctx, instancePath(resource)+"/snapshot", params, &upid)
+	if err != nil {
+		api.forgetSnapshot(resource.VMID, request.Name)
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not snapshot instance %d: %v",
+			resource.VMID, err))
+		return
+	}
+
+	log.Info().Uint64("id", resource.VMID).Str("snapshot", request.Name).Str("recurser_id", authCtx.RecurserID).
+		Msg("taking snapshot of instance")
+
+	task, err := api.startTask(authCtx, resource.VMID, "snapshot", func(ctx context.Context, t *taskRun) error {
+		err := t.wait(ctx, upid)
+		if err != nil {
+			api.forgetSnapshot(resource.VMID, request.Name)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not track snapshot: %v", err))
+		return
+	}
+
+	api.writeTaskResponse(w, r, task, http.StatusCreated, func(task Task) any {
+		return SnapshotResponse{Snapshot: request.Name, Task: task}
+	})
+}
+
+// Finds the snapshot named in the URL, writing the appropriate error and returning false if it doesn't exist.
+func (api *APIContext) resolveSnapshot(
+	ctx context.Context, w http.ResponseWriter, r *http.Request, resource *proxmox.ClusterResource,
+) (Snapshot, bool) {
+	name := chi.URLParam(r, "name")
+
+	snapshots, err := api.listInstanceSnapshots(ctx, resource)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not list snapshots: %v", err))
+		return Snapshot{}, false
+	}
+
+	for _, snapshot := range snapshots {
+		if snapshot.Name == name {
+			return snapshot, true
+		}
+	}
+
+	writeError(w, http.StatusNotFound, fmt.Sprintf("instance %d has no snapshot named %q", resource.VMID, name))
+	return Snapshot{}, false
+}
+
+func (api *APIContext) deleteSnapshot(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	authCtx := CheckAuth(r)
+
+	resource := api.resolveManagedInstance(ctx, w, r)
+	if resource == nil {
+		return
+	}
+
+	snapshot, ok := api.resolveSnapshot(ctx, w, r, resource)
+	if !ok {
+		return
+	}
+
+	var upid proxmox.UPID
+	err := api.Client.Delete(ctx, fmt.Sprintf("%s/snapshot/%s", instancePath(resource), snapshot.Name), &upid)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not delete snapshot %q: %v",
+			snapshot.Name, err))
+		return
+	}
+
+	log.Info().Uint64("id", resource.VMID).Str("snapshot", snapshot.Name).Str("recurser_id", authCtx.RecurserID).
+		Msg("deleting snapshot of instance")
+
+	task, err := api.startTask(authCtx, resource.VMID, "delete-snapshot", func(ctx context.Context, t *taskRun) error {
+		err := t.wait(ctx, upid)
+		if err != nil {
+			return err
+		}
+
+		api.forgetSnapshot(resource.VMID, snapshot.Name)
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not track snapshot deletion: %v", err))
+		return
+	}
+
+	api.writeTaskResponse(w, r, task, http.StatusOK, func(task Task) any {
+		return SnapshotResponse{Snapshot: snapshot.Name, Task: task}
+	})
+}
+
+// Rolls an instance back to a snapshot. Proxmox can only roll back stopped instances so running instances are
+// stopped first and started again afterwards. Snapshots that include RAM resume the VM on their own.
+func (api *APIContext) rollbackSnapshot(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	authCtx := CheckAuth(r)
+
+	resource := api.resolveManagedInstance(ctx, w, r)
+	if resource == nil {
+		return
+	}
+
+	snapshot, ok := api.resolveSnapshot(ctx, w, r, resource)
+	if !ok {
+		return
+	}
+
+	wasRunning := resource.Status == "running"
+
+	log.Info().Uint64("id", resource.VMID).Str("snapshot", snapshot.Name).Str("recurser_id", authCtx.RecurserID).
+		Msg("rolling back instance to snapshot")
+
+	task, err := api.startTask(authCtx, resource.VMID, "rollback", func(ctx context.Context, t *taskRun) error {
+		if wasRunning {
+			t.logf("stopping instance %d before rolling back", resource.VMID)
+
+			upid, err := api.performPowerAction(ctx, resource, PowerActionStop, PowerActionRequest{})
+			if err != nil {
+				return fmt.Errorf("could not stop instance: %w", err)
+			}
+
+			err = t.wait(ctx, upid)
+			if err != nil {
+				return fmt.Errorf("could not stop instance: %w", err)
+			}
+		}
+
+		var upid proxmox.UPID
+		err := api.Client.Post(ctx, fmt.Sprintf("%s/snapshot/%s/rollback", instancePath(resource), snapshot.Name),
+			nil, &upid)
+		if err != nil {
+			return fmt.Errorf("could not roll back to snapshot %q: %w", snapshot.Name, err)
+		}
+
+		err = t.wait(ctx, upid)
+		if err != nil {
+			return fmt.Errorf("could not roll back to snapshot %q: %w", snapshot.Name, err)
+		}
+
+		if !wasRunning || snapshot.IncludesRAM {
+			return nil
+		}
+
+		t.logf("starting instance %d again", resource.VMID)
+
+		upid, err = api.performPowerAction(ctx, resource, PowerActionStart, PowerActionRequest{})
+		if err != nil {
+			return fmt.Errorf("rolled back but could not start instance: %w", err)
+		}
+
+		return t.wait(ctx, upid)
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not track rollback: %v", err))
+		return
+	}
+
+	api.writeTaskResponse(w, r, task, http.StatusOK, func(task Task) any {
+		return SnapshotResponse{Snapshot: snapshot.Name, Task: task}
+	})
+}
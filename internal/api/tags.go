@@ -0,0 +1,138 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/luthermonson/go-proxmox"
+)
+
+// RC3 stores a small amount of metadata directly on instances as Proxmox tags so that it travels with the instance
+// (and is visible in the Proxmox UI) even if RC3's own records are lost.
+//
+// Proxmox only allows tags made up of letters, digits and the characters `_ - + .` (with the first character limited
+// to letters, digits and `_`), so a tag is encoded as `<key>.<escaped value>` where any byte of the value outside of
+// `[a-z0-9_-]` is written as `+` followed by its two digit hex code. For example the pair ("rc3-recurser", "Jane Doe")
+// becomes `rc3-recurser.+4aane+20+44oe`.
+const (
+	tagKeySize     = "rc3-size"
+	tagKeyRecurser = "rc3-recurser"
+)
+
+const (
+	tagKeyValueSeparator = "."
+	tagEscapeCharacter   = '+'
+)
+
+func isUnescapedTagByte(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') || b == '_' || b == '-'
+}
+
+func escapeTagValue(value string) string {
+	var escaped strings.Builder
+
+	for i := 0; i < len(value); i++ {
+		b := value[i]
+		if isUnescapedTagByte(b) {
+			escaped.WriteByte(b)
+			continue
+		}
+
+		fmt.Fprintf(&escaped, "%c%02x", tagEscapeCharacter, b)
+	}
+
+	return escaped.String()
+}
+
+func unescapeTagValue(escaped string) (string, error) {
+	var value strings.Builder
+
+	for i := 0; i < len(escaped); i++ {
+		b := escaped[i]
+		if b != tagEscapeCharacter {
+			value.WriteByte(b)
+			continue
+		}
+
+		if i+2 >= len(escaped) {
+			return "", fmt.Errorf("truncated escape sequence at position %d", i)
+		}
+
+		decoded, err := strconv.ParseUint(escaped[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid escape sequence at position %d: %w", i, err)
+		}
+
+		value.WriteByte(byte(decoded))
+		i += 2
+	}
+
+	return value.String(), nil
+}
+
+// Encodes a key/value pair as a single Proxmox compatible tag. Keys are expected to already be valid tag characters.
+func encodeTag(key, value string) string {
+	return key + tagKeyValueSeparator + escapeTagValue(value)
+}
+
+// Decodes a tag created by encodeTag back into its key and value.
+func decodeTag(tag string) (key, value string, err error) {
+	key, escapedValue, found := strings.Cut(tag, tagKeyValueSeparator)
+	if !found {
+		return "", "", fmt.Errorf("tag %q is not a key/value tag", tag)
+	}
+
+	value, err = unescapeTagValue(escapedValue)
+	if err != nil {
+		return "", "", fmt.Errorf("could not decode tag %q: %w", tag, err)
+	}
+
+	return key, value, nil
+}
+
+// Parses the tag string returned by Proxmox into a map of the key/value tags it contains. Tags that weren't created
+// by encodeTag (ex. ones added by hand in the Proxmox UI) are ignored.
+func parseTags(tags string) map[string]string {
+	parsed := map[string]string{}
+
+	for _, field := range splitTags(tags) {
+		key, value, err := decodeTag(field)
+		if err != nil {
+			continue
+		}
+
+		parsed[key] = value
+	}
+
+	return parsed
+}
+
+// Splits the tag string returned by Proxmox into individual tags. Proxmox returns tags separated by semicolons but
+// accepts commas and spaces too.
+func splitTags(tags string) []string {
+	return strings.FieldsFunc(tags, func(r rune) bool {
+		return r == ';' || r == ',' || r == ' '
+	})
+}
+
+// Sets a key/value tag in the tag string returned by Proxmox, replacing the key's old value and keeping every other
+// tag (including ones added by hand) as it was.
+func setTag(tags, key, value string) string {
+	updated := []string{}
+	for _, field := range splitTags(tags) {
+		fieldKey, _, err := decodeTag(field)
+		if err == nil && fieldKey == key {
+			continue
+		}
+
+		updated = append(updated, field)
+	}
+
+	return strings.Join(append(updated, encodeTag(key, value)), ";")
+}
+
+// Add tags to a container option list.
+func createTagsContainerOption(tags ...string) proxmox.ContainerOption {
+	return proxmox.ContainerOption{Name: "tags", Value: strings.Join(tags, ";")}
+}
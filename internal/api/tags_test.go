@@ -0,0 +1,126 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEscapeTagValue(t *testing.T) {
+	tests := map[string]struct {
+		value   string
+		escaped string
+	}{
+		"plain":      {value: "small-2_x", escaped: "small-2_x"},
+		"empty":      {value: "", escaped: ""},
+		"equals":     {value: "a=b", escaped: "a+3db"},
+		"at":         {value: "jane@example.com", escaped: "jane+40example+2ecom"},
+		"space":      {value: "Jane Doe", escaped: "+4aane+20+44oe"},
+		"plus":       {value: "c++", escaped: "c+2b+2b"},
+		"separator":  {value: "v1.2", escaped: "v1+2e2"},
+		"uppercase":  {value: "ABC", escaped: "+41+42+43"},
+		"non-ascii":  {value: "é", escaped: "+c3+a9"},
+		"emoji":      {value: "🦀", escaped: "+f0+9f+a6+80"},
+		"semicolons": {value: "a;b,c", escaped: "a+3bb+2cc"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			escaped := escapeTagValue(test.value)
+			if escaped != test.escaped {
+				t.Errorf("escapeTagValue(%q) = %q; want %q", test.value, escaped, test.escaped)
+			}
+
+			value, err := unescapeTagValue(escaped)
+			if err != nil {
+				t.Fatalf("unescapeTagValue(%q) returned error: %v", escaped, err)
+			}
+
+			if value != test.value {
+				t.Errorf("unescapeTagValue(%q) = %q; want %q", escaped, value, test.value)
+			}
+
+			key, value, err := decodeTag(encodeTag(tagKeyRecurser, test.value))
+			if err != nil {
+				t.Fatalf("decodeTag(encodeTag(%q)) returned error: %v", test.value, err)
+			}
+
+			if key != tagKeyRecurser || value != test.value {
+				t.Errorf("decodeTag(encodeTag(%q)) = (%q, %q); want (%q, %q)", test.value, key, value,
+					tagKeyRecurser, test.value)
+			}
+		})
+	}
+}
+
+func TestUnescapeTagValueMalformed(t *testing.T) {
+	tests := map[string]string{
+		"lone escape":       "abc+",
+		"truncated escape":  "abc+4",
+		"non-hex escape":    "abc+zz",
+		"half hex escape":   "+4g",
+		"signed hex escape": "+-1",
+	}
+
+	for name, escaped := range tests {
+		t.Run(name, func(t *testing.T) {
+			value, err := unescapeTagValue(escaped)
+			if err == nil {
+				t.Errorf("unescapeTagValue(%q) = %q; want an error", escaped, value)
+			}
+		})
+	}
+}
+
+func TestDecodeTagWithoutSeparator(t *testing.T) {
+	_, _, err := decodeTag("production")
+	if err == nil {
+		t.Errorf("decodeTag(%q) returned no error; want one", "production")
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tests := map[string]struct {
+		tags string
+		want map[string]string
+	}{
+		"empty": {
+			tags: "",
+			want: map[string]string{},
+		},
+		"rc3 tags only": {
+			tags: encodeTag(tagKeySize, "small") + ";" + encodeTag(tagKeyRecurser, "1234"),
+			want: map[string]string{tagKeySize: "small", tagKeyRecurser: "1234"},
+		},
+		"mixed with foreign tags": {
+			tags: "production;" + encodeTag(tagKeySize, "large") + ";web;" + encodeTag(tagKeyRecurser, "Jane Doe"),
+			want: map[string]string{tagKeySize: "large", tagKeyRecurser: "Jane Doe"},
+		},
+		"other separators": {
+			tags: "production, " + encodeTag(tagKeySize, "large") + " web",
+			want: map[string]string{tagKeySize: "large"},
+		},
+		"malformed escapes are skipped": {
+			tags: encodeTag(tagKeySize, "small") + ";" + tagKeyRecurser + ".+zz;other.+4",
+			want: map[string]string{tagKeySize: "small"},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := parseTags(test.tags)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseTags(%q) = %v; want %v", test.tags, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSetTag(t *testing.T) {
+	tags := "production;" + encodeTag(tagKeySize, "small") + ";" + encodeTag(tagKeyRecurser, "1234")
+
+	got := setTag(tags, tagKeySize, "large")
+	want := "production;" + encodeTag(tagKeyRecurser, "1234") + ";" + encodeTag(tagKeySize, "large")
+	if got != want {
+		t.Errorf("setTag(%q) = %q; want %q", tags, got, want)
+	}
+}
@@ -0,0 +1,325 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clintjedwards/rc3/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"github.com/luthermonson/go-proxmox"
+	"github.com/rs/zerolog/log"
+)
+
+// The most log lines we'll pull from a single Proxmox task. Proxmox only returns 50 by default which cuts off
+// things like disk clones.
+const maxTaskLogLines = 1000
+
+type TaskStatus string
+
+const (
+	TaskStatusRunning   TaskStatus = "running"
+	TaskStatusSucceeded TaskStatus = "succeeded"
+	TaskStatusFailed    TaskStatus = "failed"
+)
+
+func (api *APIContext) tasksRouter() RouteEntry {
+	router := func(router chi.Router) {
+		router.Get("/{id}", api.getTask)
+	}
+
+	return RouteEntry{
+		Pattern: "/tasks",
+		Router:  router,
+	}
+}
+
+// Task is a long running operation on an instance (ex. creating, deleting or starting it). Operations are carried
+// out by one or more Proxmox tasks; RC3 tracks them to completion so callers can find out how things went.
+type Task struct {
+	ID         string     `json:"id"`
+	InstanceID uint64     `json:"instance_id"`
+	Operation  string     `json:"operation"`
+	Status     TaskStatus `json:"status"`
+	UPID       string     `json:"upid"` // The Proxmox task currently (or last) being waited on.
+	Node       string     `json:"node"`
+	ExitStatus string     `json:"exit_status"` // "OK" on success, otherwise what went wrong.
+	Log        []string   `json:"log"`
+	Created    int64      `json:"created"`  // Unix seconds
+	Finished   int64      `json:"finished"` // Unix seconds; 0 means the task is still running.
+}
+
+func newTaskFromStorage(task *storage.Task) Task {
+	lines := []string{}
+	if task.Log != "" {
+		lines = strings.Split(task.Log, "\n")
+	}
+
+	return Task{
+		ID:         task.ID,
+		InstanceID: task.InstanceID,
+		Operation:  task.Operation,
+		Status:     TaskStatus(task.Status),
+		UPID:       task.UPID,
+		Node:       task.Node,
+		ExitStatus: task.ExitStatus,
+		Log:        lines,
+		Created:    task.Created,
+		Finished:   task.Finished,
+	}
+}
+
+// taskRun is handed to the function doing a task's work so it can report the Proxmox tasks it kicks off.
+type taskRun struct {
+	api    *APIContext
+	record *storage.Task
+}
+
+func (t *taskRun) save() {
+	if err := t.api.DB.UpdateTask(t.record); err != nil {
+		log.Error().Err(err).Str("task", t.record.ID).Msg("could not save task progress")
+	}
+}
+
+func (t *taskRun) appendLog(lines ...string) {
+	for _, line := range lines {
+		if t.record.Log == "" {
+			t.record.Log = line
+		} else {
+			t.record.Log += "\n" + line
+		}
+	}
+}
+
+// Adds a line to the task's log.
+func (t *taskRun) logf(format string, args ...any) {
+	t.appendLog(fmt.Sprintf(format, args...))
+	t.save()
+}
+
+// Returned (wrapped) when RC3 stops waiting on a Proxmox task without finding out how it ended, ex. because it timed
+// out. The Proxmox task may still be running and could yet succeed, so nothing it touches should be torn down.
+var errTaskUnfinished = errors.New("task did not finish")
+
+// Blocks until a Proxmox task finishes, recording it as the task currently being waited on and copying its log
+// over once it's done. Returns an error if the Proxmox task failed or took longer than the configured timeout.
+func (t *taskRun) wait(ctx context.Context, upid proxmox.UPID) error {
+	return t.waitFor(ctx, upid, t.api.ProxmoxConfig.TaskTimeout)
+}
+
+// Like wait, but without a timeout. Used for tasks that copy whole disks (clones, backups and restores), which take
+// as long as the disks are big.
+func (t *taskRun) waitLong(ctx context.Context, upid proxmox.UPID) error {
+	return t.waitFor(ctx, upid, time.Duration(math.MaxInt64))
+}
+
+func (t *taskRun) waitFor(ctx context.Context, upid proxmox.UPID, timeout time.Duration) error {
+	task := proxmox.NewTask(upid, t.api.Client)
+	if task == nil {
+		return nil
+	}
+
+	t.record.UPID = string(upid)
+	t.record.Node = task.Node
+	t.save()
+
+	err := task.Wait(ctx, proxmox.DefaultWaitInterval, timeout)
+	if err != nil {
+		return fmt.Errorf("could not wait on task %s: %w: %w", upid, errTaskUnfinished, err)
+	}
+
+	lines, err := task.Log(ctx, 0, maxTaskLogLines)
+	if err != nil {
+		log.Warn().Err(err).Str("upid", string(upid)).Msg("could not get task log")
+	}
+
+	// Several Proxmox tasks can end up in the same log so mark where each one starts.
+	t.appendLog(fmt.Sprintf("--- %s ---", upid))
+	for i := 0; i < len(lines); i++ {
+		t.appendLog(lines[i])
+	}
+	t.save()
+
+	if task.IsFailed {
+		return fmt.Errorf("task %s failed: %s", upid, task.ExitStatus)
+	}
+
+	return nil
+}
+
+// Records a new task and runs it in the background. The work function does the actual work, using the taskRun to
+// wait on any Proxmox tasks it starts; whatever it returns decides whether the task succeeded.
+func (api *APIContext) startTask(
+	authCtx AuthContext, instanceID uint64, operation string, work func(ctx context.Context, t *taskRun) error,
+) (*storage.Task, error) {
+	id, err := randomString(9)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate task id: %w", err)
+	}
+
+	record := &storage.Task{
+		ID:         id,
+		RecurserID: authCtx.RecurserID,
+		InstanceID: instanceID,
+		Operation:  operation,
+		Status:     string(TaskStatusRunning),
+		Created:    time.Now().Unix(),
+	}
+
+	err = api.DB.InsertTask(record)
+	if err != nil {
+		return nil, fmt.Errorf("could not save task: %w", err)
+	}
+
+	done := make(chan struct{})
+
+	api.tasksMu.Lock()
+	api.runningTasks[id] = done
+	api.tasksMu.Unlock()
+
+	// The caller gets its own copy; the one below is owned by the goroutine until the task finishes.
+	snapshot := *record
+
+	go func() {
+		run := &taskRun{api: api, record: record}
+
+		err := work(context.Background(), run)
+
+		record.Finished = time.Now().Unix()
+		if err != nil {
+			record.Status = string(TaskStatusFailed)
+			record.ExitStatus = err.Error()
+			log.Error().Err(err).Str("task", id).Str("operation", operation).Uint64("instance_id", instanceID).
+				Msg("task failed")
+		} else {
+			record.Status = string(TaskStatusSucceeded)
+			record.ExitStatus = "OK"
+			log.Info().Str("task", id).Str("operation", operation).Uint64("instance_id", instanceID).
+				Msg("task succeeded")
+		}
+		run.save()
+
+		api.tasksMu.Lock()
+		delete(api.runningTasks, id)
+		api.tasksMu.Unlock()
+		close(done)
+	}()
+
+	return &snapshot, nil
+}
+
+// Waits for a task to finish, giving up once the context is done, and returns its latest state.
+func (api *APIContext) awaitTask(ctx context.Context, id string) (*storage.Task, error) {
+	api.tasksMu.Lock()
+	done, running := api.runningTasks[id]
+	api.tasksMu.Unlock()
+
+	if running {
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+	}
+
+	return api.DB.GetTask(id)
+}
+
+// Marks any tasks left over from a previous run as failed. Nothing is waiting on them anymore so they would otherwise
+// look like they're running forever.
+func (api *APIContext) failInterruptedTasks() {
+	count, err := api.DB.FailUnfinishedTasks(string(TaskStatusFailed), "interrupted by an RC3 restart",
+		time.Now().Unix())
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not clean up interrupted tasks")
+	}
+
+	if count > 0 {
+		log.Warn().Int64("count", count).Msg("marked tasks interrupted by restart as failed")
+	}
+}
+
+// Works out how long to hold a response from ?wait, which is either true (as long as the server allows) or how long
+// the caller is willing to wait (ex. "30s"). Clients pass a duration so they know how long to keep the request open
+// without having to know the server's maximum.
+func (api *APIContext) taskWait(r *http.Request) time.Duration {
+	value := r.URL.Query().Get("wait")
+
+	if wait, err := strconv.ParseBool(value); err == nil {
+		if wait {
+			return api.ServerConfig.MaxTaskWait
+		}
+		return 0
+	}
+
+	wait, err := time.ParseDuration(value)
+	if err != nil || wait <= 0 {
+		return 0
+	}
+
+	return min(wait, api.ServerConfig.MaxTaskWait)
+}
+
+// Responds to a request that kicked off a task. Callers can ask to wait on the task with ?wait, in which case the
+// response is held until the task finishes (up to the configured maximum). A finished task responds with doneCode,
+// a failed one with an error and one that is still running with 202.
+func (api *APIContext) writeTaskResponse(
+	w http.ResponseWriter, r *http.Request, record *storage.Task, doneCode int, response func(task Task) any,
+) {
+	wait := api.taskWait(r)
+	if wait > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		defer cancel()
+
+		latest, err := api.awaitTask(ctx, record.ID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get task %s: %v", record.ID, err))
+			return
+		}
+		record = latest
+	}
+
+	switch TaskStatus(record.Status) {
+	case TaskStatusSucceeded:
+		writeResponse(w, doneCode, response(newTaskFromStorage(record)))
+	case TaskStatusFailed:
+		writeError(w, http.StatusInternalServerError,
+			fmt.Sprintf("task %s failed: %s", record.ID, record.ExitStatus))
+	default:
+		writeResponse(w, http.StatusAccepted, response(newTaskFromStorage(record)))
+	}
+}
+
+type GetTaskResponse struct {
+	Task Task `json:"task"`
+}
+
+func (api *APIContext) getTask(w http.ResponseWriter, r *http.Request) {
+	authCtx := CheckAuth(r)
+	id := chi.URLParam(r, "id")
+
+	record, err := api.DB.GetTask(id)
+	if err != nil {
+		if errors.Is(err, storage.ErrEntityNotFound) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("task %s not found", id))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get task %s: %v", id, err))
+		return
+	}
+
+	// Tasks can include details about someone's instance so, like managing instances, only the recurser who started
+	// the task (or an admin) can see it. Not found rather than forbidden so task IDs can't be probed.
+	if record.RecurserID != authCtx.RecurserID && !authCtx.IsAdmin {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("task %s not found", id))
+		return
+	}
+
+	writeResponse(w, http.StatusOK, GetTaskResponse{
+		Task: newTaskFromStorage(record),
+	})
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/clintjedwards/rc3/internal/conf"
+)
+
+func TestTaskWait(t *testing.T) {
+	api := &APIContext{ServerConfig: &conf.Server{MaxTaskWait: 2 * time.Minute}}
+
+	tests := map[string]struct {
+		wait string
+		want time.Duration
+	}{
+		"not set":           {wait: "", want: 0},
+		"true":              {wait: "true", want: 2 * time.Minute},
+		"false":             {wait: "false", want: 0},
+		"duration":          {wait: "30s", want: 30 * time.Second},
+		"duration at max":   {wait: "2m0s", want: 2 * time.Minute},
+		"duration past max": {wait: "10m", want: 2 * time.Minute},
+		"negative":          {wait: "-30s", want: 0},
+		"invalid":           {wait: "soon", want: 0},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/instances/100/start?wait="+test.wait, nil)
+
+			got := api.taskWait(r)
+			if got != test.want {
+				t.Errorf("got wait %s; want %s", got, test.want)
+			}
+		})
+	}
+}
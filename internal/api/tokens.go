@@ -0,0 +1,292 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/clintjedwards/rc3/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// All tokens start with this prefix so they are easy to recognize (and easy for secret scanners to find).
+const tokenPrefix = "rc3_"
+
+const maxTokenNameLength = 64
+
+func (api *APIContext) tokensRouter() RouteEntry {
+	router := func(router chi.Router) {
+		router.Get("/", api.listTokens)
+		router.Post("/", api.createToken)
+		router.Patch("/{id}", api.updateToken)
+		router.Delete("/{id}", api.revokeToken)
+	}
+
+	return RouteEntry{
+		Pattern: "/tokens",
+		Router:  router,
+	}
+}
+
+// Token is the public view of a personal API token. The token secret is never included.
+type Token struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Created  int64  `json:"created"`   // Unix seconds
+	Expires  int64  `json:"expires"`   // Unix seconds; 0 means the token never expires.
+	LastUsed int64  `json:"last_used"` // Unix seconds; 0 means the token has never been used.
+}
+
+func newTokenFromStorage(token *storage.Token) Token {
+	return Token{
+		ID:       token.ID,
+		Name:     token.Name,
+		Created:  token.Created,
+		Expires:  token.Expires,
+		LastUsed: token.LastUsed,
+	}
+}
+
+func hashToken(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+func randomString(numBytes int) (string, error) {
+	b := make([]byte, numBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func validateTokenName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("token name cannot be empty")
+	}
+
+	if len(name) > maxTokenNameLength {
+		return fmt.Errorf("token name cannot be longer than %d characters", maxTokenNameLength)
+	}
+
+	return nil
+}
+
+// Creates and stores a new token for the recurser, returning the stored token and its secret.
+func (api *APIContext) issueToken(authCtx AuthContext, name string, ttl time.Duration) (*storage.Token, string, error) {
+	id, err := randomString(9)
+	if err != nil {
+		return nil, "", err
+	}
+
+	secretBody, err := randomString(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	secret := tokenPrefix + secretBody
+	now := time.Now()
+
+	var expires int64
+	if ttl > 0 {
+		expires = now.Add(ttl).Unix()
+	}
+
+	token := &storage.Token{
+		ID:           id,
+		RecurserID:   authCtx.RecurserID,
+		RecurserName: authCtx.Name,
+		Name:         name,
+		Hash:         hashToken(secret),
+		Created:      now.Unix(),
+		Expires:      expires,
+	}
+
+	if err := api.DB.InsertToken(token); err != nil {
+		return nil, "", err
+	}
+
+	return token, secret, nil
+}
+
+// Looks up the owner of a bearer token, making sure it is still valid.
+func (api *APIContext) authenticateToken(secret string) (AuthContext, error) {
+	if !strings.HasPrefix(secret, tokenPrefix) {
+		return AuthContext{}, fmt.Errorf("malformed token")
+	}
+
+	token, err := api.DB.GetTokenByHash(hashToken(secret))
+	if err != nil {
+		if errors.Is(err, storage.ErrEntityNotFound) {
+			return AuthContext{}, fmt.Errorf("token not found; it may have been revoked")
+		}
+		return AuthContext{}, err
+	}
+
+	now := time.Now().Unix()
+
+	if token.Expires != 0 && now > token.Expires {
+		return AuthContext{}, fmt.Errorf("token expired")
+	}
+
+	if err := api.DB.UpdateTokenLastUsed(token.ID, now); err != nil {
+		log.Error().Err(err).Str("token_id", token.ID).Msg("could not update token last used time")
+	}
+
+	return AuthContext{
+		RecurserID: token.RecurserID,
+		Name:       token.RecurserName,
+	}, nil
+}
+
+type ListTokensResponse struct {
+	Tokens []Token `json:"tokens"`
+}
+
+func (api *APIContext) listTokens(w http.ResponseWriter, r *http.Request) {
+	authCtx := CheckAuth(r)
+
+	tokens, err := api.DB.ListTokens(authCtx.RecurserID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not list tokens: %v", err))
+		return
+	}
+
+	returnedTokens := []Token{}
+	for _, token := range tokens {
+		returnedTokens = append(returnedTokens, newTokenFromStorage(&token))
+	}
+
+	writeResponse(w, http.StatusOK, ListTokensResponse{
+		Tokens: returnedTokens,
+	})
+}
+
+type CreateTokenRequest struct {
+	Name string `json:"name"`
+
+	// How long the token should be valid for as a duration string (ex. "720h"). Omit for a token that never
+	// expires.
+	ExpiresIn string `json:"expires_in"`
+}
+
+type CreateTokenResponse struct {
+	Token Token `json:"token"`
+
+	// The token itself. This is the only time it is ever returned.
+	Secret string `json:"secret"`
+}
+
+func (api *APIContext) createToken(w http.ResponseWriter, r *http.Request) {
+	authCtx := CheckAuth(r)
+
+	var request CreateTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	if err := validateTokenName(request.Name); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var ttl time.Duration
+	if request.ExpiresIn != "" {
+		var err error
+		ttl, err = time.ParseDuration(request.ExpiresIn)
+		if err != nil || ttl <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid expires_in %q; must be a positive duration like '720h'",
+				request.ExpiresIn))
+			return
+		}
+	}
+
+	token, secret, err := api.issueToken(authCtx, request.Name, ttl)
+	if err != nil {
+		if errors.Is(err, storage.ErrEntityExists) {
+			writeError(w, http.StatusConflict, fmt.Sprintf("a token named %q already exists", request.Name))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not create token: %v", err))
+		return
+	}
+
+	writeResponse(w, http.StatusCreated, CreateTokenResponse{
+		Token:  newTokenFromStorage(token),
+		Secret: secret,
+	})
+}
+
+type UpdateTokenRequest struct {
+	Name string `json:"name"`
+}
+
+type UpdateTokenResponse struct {
+	Token Token `json:"token"`
+}
+
+func (api *APIContext) updateToken(w http.ResponseWriter, r *http.Request) {
+	authCtx := CheckAuth(r)
+	id := chi.URLParam(r, "id")
+
+	var request UpdateTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	if err := validateTokenName(request.Name); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err := api.DB.UpdateTokenName(authCtx.RecurserID, id, request.Name)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrEntityNotFound):
+			writeError(w, http.StatusNotFound, fmt.Sprintf("token %q not found", id))
+		case errors.Is(err, storage.ErrEntityExists):
+			writeError(w, http.StatusConflict, fmt.Sprintf("a token named %q already exists", request.Name))
+		default:
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not update token: %v", err))
+		}
+		return
+	}
+
+	token, err := api.DB.GetToken(authCtx.RecurserID, id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not get updated token: %v", err))
+		return
+	}
+
+	writeResponse(w, http.StatusOK, UpdateTokenResponse{
+		Token: newTokenFromStorage(token),
+	})
+}
+
+func (api *APIContext) revokeToken(w http.ResponseWriter, r *http.Request) {
+	authCtx := CheckAuth(r)
+	id := chi.URLParam(r, "id")
+
+	err := api.DB.DeleteToken(authCtx.RecurserID, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrEntityNotFound) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("token %q not found", id))
+			return
+		}
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("could not revoke token: %v", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
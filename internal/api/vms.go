@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/clintjedwards/rc3/internal/conf"
+	"github.com/luthermonson/go-proxmox"
+)
+
+// Proxmox expects the cloud-init sshkeys option to be URL encoded, with spaces encoded as %20 rather than '+'.
+func encodeCloudInitSSHKeys(keys []string) string {
+	return strings.ReplaceAll(url.QueryEscape(strings.Join(keys, "\n")), "+", "%20")
+}
+
+// The settings applied to a freshly cloned VM. Sizing is done here rather than in the template so the same template
+// can be used for every size.
+func (api *APIContext) getVMOptions(
+	name string, size conf.Size, image conf.Image, owner string, sshKeys []string,
+) []proxmox.VirtualMachineOption {
+	user := image.User
+	if user == "" {
+		user = api.ProxmoxConfig.VMUser
+	}
+
+	options := []proxmox.VirtualMachineOption{
+		{Name: "name", Value: name}, // Cloud-init uses the VM name as the hostname.
+		{Name: "onboot", Value: 1},  // Start on boot
+		{Name: "agent", Value: 1},   // Enable the QEMU guest agent
+		{Name: "cores", Value: size.Cores},
+		{Name: "cpulimit", Value: size.CPULimit},
+		{Name: "memory", Value: size.Memory},
+		{Name: "ciuser", Value: user},
+		{Name: "ipconfig0", Value: "ip=dhcp,ip6=auto"},
+		{Name: "tags", Value: strings.Join([]string{
+			encodeTag(tagKeySize, size.Name),
+			encodeTag(tagKeyRecurser, owner),
+		}, ";")},
+	}
+
+	if len(sshKeys) > 0 {
+		options = append(options, proxmox.VirtualMachineOption{Name: "sshkeys", Value: encodeCloudInitSSHKeys(sshKeys)})
+	}
+
+	return options
+}
+
+// Starts creating a new VM by cloning the image's cloud-init template and returns the clone's Proxmox task. Once
+// the clone finishes the VM still needs to be provisioned.
+func (api *APIContext) createVM(
+	ctx context.Context, id int, node string, image conf.Image, request CreateInstanceRequest,
+) (proxmox.UPID, error) {
+	template, err := api.findInstance(ctx, uint64(image.VMTemplateID))
+	if err != nil {
+		return "", fmt.Errorf("could not find vm template %d: %w", image.VMTemplateID, err)
+	}
+
+	cloneOptions := proxmox.VirtualMachineCloneOptions{
+		NewID:   id,
+		Name:    request.Name,
+		Full:    1,
+		Storage: api.ProxmoxConfig.InstanceStorage,
+	}
+
+	if node != template.Node {
+		cloneOptions.Target = node
+	}
+
+	var upid proxmox.UPID
+	err = api.Client.Post(ctx, instancePath(template)+"/clone", cloneOptions, &upid)
+	if err != nil {
+		return "", fmt.Errorf("could not clone vm template: %w", err)
+	}
+
+	return upid, nil
+}
+
+// Finishes setting up a cloned VM: waits for the clone, applies sizing and cloud-init settings, grows the root disk
+// and boots it.
+func (api *APIContext) provisionVM(
+	ctx context.Context, t *taskRun, cloneUPID proxmox.UPID, node string, id int, authCtx AuthContext,
+	size conf.Size, image conf.Image, sshKeys []string, request CreateInstanceRequest,
+) error {
+	options := api.getVMOptions(request.Name, size, image, authCtx.RecurserID, sshKeys)
+
+	err := t.waitLong(ctx, cloneUPID)
+	if err != nil {
+		return fmt.Errorf("clone did not complete: %w", err)
+	}
+
+	vmPath := fmt.Sprintf("/nodes/%s/qemu/%d", node, id)
+
+	config := map[string]any{}
+	for _, option := range options {
+		config[option.Name] = option.Value
+	}
+
+	var upid proxmox.UPID
+	err = api.Client.Post(ctx, vmPath+"/config", config, &upid)
+	if err != nil {
+		return fmt.Errorf("could not configure vm: %w", err)
+	}
+
+	err = t.wait(ctx, upid)
+	if err != nil {
+		return fmt.Errorf("could not configure vm: %w", err)
+	}
+
+	// Proxmox refuses to shrink disks so a template with a larger disk than the size asks for will fail here.
+	upid = ""
+	err = api.Client.Put(ctx, vmPath+"/resize", map[string]string{
+		"disk": api.ProxmoxConfig.VMRootDisk,
+		"size": fmt.Sprintf("%dG", size.Disk),
+	}, &upid)
+	if err != nil {
+		return fmt.Errorf("could not resize root disk: %w", err)
+	}
+
+	err = t.wait(ctx, upid)
+	if err != nil {
+		return fmt.Errorf("could not resize root disk: %w", err)
+	}
+
+	upid = ""
+	err = api.Client.Post(ctx, vmPath+"/status/start", nil, &upid)
+	if err != nil {
+		return fmt.Errorf("could not start vm: %w", err)
+	}
+
+	return t.wait(ctx, upid)
+}
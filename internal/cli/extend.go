@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/clintjedwards/rc3/internal/api"
+	"github.com/clintjedwards/rc3/internal/cli/global"
+	"github.com/spf13/cobra"
+)
+
+var cmdExtend = &cobra.Command{
+	Use:   "extend <id> <duration>",
+	Short: "Keep an instance around for longer",
+	Long: `Keep an instance around for longer.
+
+Instances expire after a while; expired instances are stopped and later deleted. Extending an instance pushes back
+when it expires by the given duration. Instances that have already expired are extended from now and need to be
+started again.`,
+	Example: `$ rc3 extend 104 24h`,
+	Args:    cobra.ExactArgs(2),
+	RunE:    extend,
+}
+
+func extend(_ *cobra.Command, args []string) error {
+	cl := global.CLIContext
+
+	var response api.ExtendInstanceResponse
+	err := cl.Request(http.MethodPost, fmt.Sprintf("/instances/%s/extend", args[0]), api.ExtendInstanceRequest{
+		ExpiresIn: args[1],
+	}, &response)
+	if err != nil {
+		cl.Fmt.PrintErr(fmt.Sprintf("could not extend instance %s: %v", args[0], err))
+		cl.Fmt.Finish()
+		return err
+	}
+
+	cl.Fmt.PrintSuccess(fmt.Sprintf("Instance %s now expires at %s", args[0],
+		time.Unix(response.Expires, 0).Format(time.RFC3339)))
+	cl.Fmt.Finish()
+	return nil
+}
@@ -0,0 +1,97 @@
+package global
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/clintjedwards/rc3/internal/api"
+)
+
+var httpClient = &http.Client{}
+
+// How long to wait on an ordinary request to RC3.
+const requestTimeout = 30 * time.Second
+
+// TaskWait is how long commands ask the server to hold a response while the task behind it finishes (sent as
+// ?wait=<duration>). The server holds it for no longer than that, or less if its own max_task_wait is shorter.
+const TaskWait = 2 * time.Minute
+
+// The host in config is allowed to omit the scheme (ex. "localhost:8080"), in which case plain HTTP is assumed.
+func (c *Context) baseURL() string {
+	host := strings.TrimSuffix(c.Config.Host, "/")
+	if !strings.HasPrefix(host, "http://") && !strings.HasPrefix(host, "https://") {
+		host = "http://" + host
+	}
+
+	return host
+}
+
+// URL returns the full URL for a path on the RC3 server.
+func (c *Context) URL(path string) string {
+	return c.baseURL() + path
+}
+
+// Request makes an authenticated call to the RC3 API. The request, if not nil, is sent as JSON and the JSON response
+// is decoded into response, if not nil. Error responses from the API are turned into errors.
+func (c *Context) Request(method, path string, request, response any) error {
+	var body io.Reader
+	if request != nil {
+		payload, err := json.Marshal(request)
+		if err != nil {
+			return fmt.Errorf("could not encode request: %w", err)
+		}
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequest(method, c.URL("/api"+path), body)
+	if err != nil {
+		return err
+	}
+
+	timeout := requestTimeout
+	if wait, err := time.ParseDuration(req.URL.Query().Get("wait")); err == nil {
+		timeout += wait
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	if request != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if c.Config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Config.Token)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not reach RC3 at %s: %w", c.baseURL(), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var errResp api.ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil || errResp.Error == "" {
+			return fmt.Errorf("request failed: %s", resp.Status)
+		}
+		return fmt.Errorf("%s: %s", errResp.Error, errResp.ErrorDetails)
+	}
+
+	if response == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return fmt.Errorf("could not decode response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/clintjedwards/rc3/internal/api"
+	"github.com/clintjedwards/rc3/internal/cli/global"
+	"github.com/spf13/cobra"
+)
+
+var cmdList = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List instances",
+	Long: `List instances along with the command to SSH into each running one (or run rc3 ssh <name>).
+
+Addresses can take a little while to show up after an instance starts, especially for VMs which report them
+through the QEMU guest agent.`,
+	Example: `$ rc3 list`,
+	Args:    cobra.NoArgs,
+	RunE:    list,
+}
+
+// Returns the command to SSH into an instance, going through the configured jump host if there is one. Returns an
+// empty string if the instance has no addresses.
+func sshCommand(instance api.Instance, jumpHost string) string {
+	if sshAddress(instance) == "" {
+		return ""
+	}
+
+	return "ssh " + strings.Join(sshArgs(instance, jumpHost, nil), " ")
+}
+
+func list(_ *cobra.Command, _ []string) error {
+	cl := global.CLIContext
+
+	var response api.GetInstancesResponse
+	err := cl.Request(http.MethodGet, "/instances", nil, &response)
+	if err != nil {
+		cl.Fmt.PrintErr(fmt.Sprintf("could not list instances: %v", err))
+		cl.Fmt.Finish()
+		return err
+	}
+
+	if len(response.Instances) == 0 {
+		cl.Fmt.Println("No instances found")
+	}
+
+	for _, instance := range response.Instances {
+		addresses := strings.Join(append(instance.IPv4, instance.IPv6...), ", ")
+		if addresses == "" {
+			addresses = "-"
+		}
+
+		cl.Fmt.Println(fmt.Sprintf("%-6d %-24s %-9s %-8s %-8s %-10s %s", instance.ID, instance.Name, instance.Kind,
+			instance.Size, instance.Status, instance.Recurser, addresses))
+
+		ssh := sshCommand(instance, cl.Config.JumpHost)
+		if ssh != "" {
+			cl.Fmt.Println(fmt.Sprintf("       %s", ssh))
+		}
+	}
+
+	cl.Fmt.Finish()
+	return nil
+}
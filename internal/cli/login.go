@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/clintjedwards/rc3/internal/api"
+	"github.com/clintjedwards/rc3/internal/cli/global"
+	"github.com/clintjedwards/rc3/internal/conf"
+	"github.com/spf13/cobra"
+)
+
+var cmdLogin = &cobra.Command{
+	Use:   "login",
+	Short: "Log in to RC3 and save an API token for the CLI",
+	Long: `Log in to RC3 and save an API token for the CLI.
+
+Without any flags this prints a link to log in with your Recurse Center account. Once logged in, the page creates a
+new personal API token which you paste back into the prompt.
+
+Tokens created elsewhere (ex. with 'rc3 token create') can be saved directly with --token.
+
+The token is saved to your CLI config file (~/.rc3.toml by default).`,
+	Example: `$ rc3 login
+$ rc3 login --token rc3_abc123`,
+	RunE: login,
+}
+
+func init() {
+	cmdLogin.Flags().String("token", "", "save this token instead of logging in through the browser")
+}
+
+func login(cmd *cobra.Command, _ []string) error {
+	cl := global.CLIContext
+
+	token, _ := cmd.Flags().GetString("token")
+	if token == "" {
+		cl.Fmt.Println(fmt.Sprintf("Log in to RC3 by visiting the link below:\n\n  %s\n", cl.URL("/auth/cli")))
+		token = strings.TrimSpace(cl.Fmt.PrintQuestion("Paste your token: "))
+	}
+
+	if token == "" {
+		cl.Fmt.PrintErr("no token provided")
+		cl.Fmt.Finish()
+		return fmt.Errorf("no token provided")
+	}
+
+	// Make sure the token actually works before we save it.
+	cl.Config.Token = token
+	cl.Fmt.Print("Verifying token")
+
+	err := cl.Request(http.MethodGet, "/tokens", nil, &api.ListTokensResponse{})
+	if err != nil {
+		cl.Fmt.PrintErr(fmt.Sprintf("could not verify token: %v", err))
+		cl.Fmt.Finish()
+		return err
+	}
+
+	path, err := conf.SaveCLIConfigValue("token", token)
+	if err != nil {
+		cl.Fmt.PrintErr(fmt.Sprintf("could not save token: %v", err))
+		cl.Fmt.Finish()
+		return err
+	}
+
+	cl.Fmt.PrintSuccess(fmt.Sprintf("Logged in; token saved to %s", path))
+	cl.Fmt.Finish()
+	return nil
+}
@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/clintjedwards/rc3/internal/api"
+	"github.com/clintjedwards/rc3/internal/cli/global"
+	"github.com/spf13/cobra"
+)
+
+var cmdPin = &cobra.Command{
+	Use:   "pin <id>",
+	Short: "Keep an instance on even when it is idle",
+	Long: `Keep an instance on even when it is idle.
+
+Instances that haven't used any CPU or network for a while are shut down to free up the cluster. Pinned instances
+are left running.`,
+	Example: `$ rc3 pin 104`,
+	Args:    cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		return setPinned(args[0], true)
+	},
+}
+
+var cmdUnpin = &cobra.Command{
+	Use:     "unpin <id>",
+	Short:   "Let an instance be shut down when it is idle",
+	Example: `$ rc3 unpin 104`,
+	Args:    cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		return setPinned(args[0], false)
+	},
+}
+
+func setPinned(id string, pinned bool) error {
+	cl := global.CLIContext
+
+	method := http.MethodPut
+	if !pinned {
+		method = http.MethodDelete
+	}
+
+	var response api.PinInstanceResponse
+	err := cl.Request(method, fmt.Sprintf("/instances/%s/pin", id), nil, &response)
+	if err != nil {
+		cl.Fmt.PrintErr(fmt.Sprintf("could not update instance %s: %v", id, err))
+		cl.Fmt.Finish()
+		return err
+	}
+
+	if response.Pinned {
+		cl.Fmt.PrintSuccess(fmt.Sprintf("Pinned instance %s; it will stay on when idle", id))
+	} else {
+		cl.Fmt.PrintSuccess(fmt.Sprintf("Unpinned instance %s; it will be shut down when idle", id))
+	}
+	cl.Fmt.Finish()
+	return nil
+}
@@ -0,0 +1,36 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/clintjedwards/rc3/internal/api"
+	"github.com/clintjedwards/rc3/internal/cli/global"
+)
+
+// Asks the API to perform a power action on an instance and waits for it to finish, printing progress as it goes.
+func powerAction(action api.PowerAction, id string, request api.PowerActionRequest, progress, done string) error {
+	cl := global.CLIContext
+
+	cl.Fmt.Print(fmt.Sprintf("%s instance %s", progress, id))
+
+	var response api.PowerActionResponse
+	err := cl.Request(http.MethodPost, fmt.Sprintf("/instances/%s/%s?wait=%s", id, action, global.TaskWait), request, &response)
+	if err != nil {
+		cl.Fmt.PrintErr(fmt.Sprintf("could not %s instance %s: %v", action, id, err))
+		cl.Fmt.Finish()
+		return err
+	}
+
+	if response.Task.Status == api.TaskStatusRunning {
+		cl.Fmt.PrintSuccess(fmt.Sprintf("Instance %s is still %s (task: %s)", id, strings.ToLower(progress),
+			response.Task.ID))
+		cl.Fmt.Finish()
+		return nil
+	}
+
+	cl.Fmt.PrintSuccess(fmt.Sprintf("%s instance %s", done, id))
+	cl.Fmt.Finish()
+	return nil
+}
@@ -0,0 +1,18 @@
+package cli
+
+import (
+	"github.com/clintjedwards/rc3/internal/api"
+	"github.com/spf13/cobra"
+)
+
+var cmdReboot = &cobra.Command{
+	Use:     "reboot <id>",
+	Short:   "Reboot a running instance",
+	Example: `$ rc3 reboot 104`,
+	Args:    cobra.ExactArgs(1),
+	RunE:    reboot,
+}
+
+func reboot(_ *cobra.Command, args []string) error {
+	return powerAction(api.PowerActionReboot, args[0], api.PowerActionRequest{}, "Rebooting", "Rebooted")
+}
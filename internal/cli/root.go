@@ -7,6 +7,8 @@ import (
 
 	"github.com/clintjedwards/rc3/internal/cli/global"
 	"github.com/clintjedwards/rc3/internal/cli/service"
+	"github.com/clintjedwards/rc3/internal/cli/snapshot"
+	"github.com/clintjedwards/rc3/internal/cli/token"
 	"github.com/clintjedwards/rc3/internal/conf"
 	"github.com/spf13/cobra"
 )
@@ -34,7 +36,19 @@ Great for quick presentations or internal recurse only applications.
 func init() {
 	RootCmd.SetVersionTemplate(humanizeVersion(appVersion))
 	RootCmd.AddCommand(cmdUp)
+	RootCmd.AddCommand(cmdLogin)
+	RootCmd.AddCommand(cmdList)
+	RootCmd.AddCommand(cmdStart)
+	RootCmd.AddCommand(cmdStop)
+	RootCmd.AddCommand(cmdReboot)
+	RootCmd.AddCommand(cmdExtend)
+	RootCmd.AddCommand(cmdPin)
+	RootCmd.AddCommand(cmdUnpin)
+	RootCmd.AddCommand(cmdSSH)
+	RootCmd.AddCommand(cmdSSHConfig)
 	RootCmd.AddCommand(service.CmdService)
+	RootCmd.AddCommand(snapshot.CmdSnapshot)
+	RootCmd.AddCommand(token.CmdToken)
 }
 
 func Execute() error {
@@ -0,0 +1,43 @@
+package snapshot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/clintjedwards/rc3/internal/api"
+	"github.com/clintjedwards/rc3/internal/cli/global"
+	"github.com/spf13/cobra"
+)
+
+var CmdSnapshot = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Manage instance snapshots",
+	Long: `Manage instance snapshots.
+
+Snapshots save an instance exactly as it is so it can be rolled back if an experiment goes wrong.`,
+}
+
+// Makes a snapshot request that kicks off a task and waits for it to finish, printing progress as it goes.
+func snapshotTask(method, path string, request any, action, progress, done string) error {
+	cl := global.CLIContext
+
+	cl.Fmt.Print(progress)
+
+	var response api.SnapshotResponse
+	err := cl.Request(method, path+"?wait="+global.TaskWait.String(), request, &response)
+	if err != nil {
+		cl.Fmt.PrintErr(fmt.Sprintf("could not %s: %v", action, err))
+		cl.Fmt.Finish()
+		return err
+	}
+
+	if response.Task.Status == api.TaskStatusRunning {
+		cl.Fmt.PrintSuccess(fmt.Sprintf("Still %s (task: %s)", strings.ToLower(progress), response.Task.ID))
+		cl.Fmt.Finish()
+		return nil
+	}
+
+	cl.Fmt.PrintSuccess(fmt.Sprintf("%s %q", done, response.Snapshot))
+	cl.Fmt.Finish()
+	return nil
+}
@@ -0,0 +1,45 @@
+package snapshot
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/clintjedwards/rc3/internal/api"
+	"github.com/spf13/cobra"
+)
+
+var cmdSnapshotCreate = &cobra.Command{
+	Use:   "create <instance id> [name]",
+	Short: "Take a snapshot of an instance",
+	Long: `Take a snapshot of an instance.
+
+The snapshot is named after the current time if no name is given.`,
+	Example: `$ rc3 snapshot create 104
+$ rc3 snapshot create 104 before-upgrade --description "before upgrading postgres"
+$ rc3 snapshot create 104 warm --include-ram`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: snapshotCreate,
+}
+
+func init() {
+	cmdSnapshotCreate.Flags().String("description", "", "a note about what the snapshot is for")
+	cmdSnapshotCreate.Flags().Bool("include-ram", false, "save the VM's memory too so rolling back resumes it; VMs only")
+	CmdSnapshot.AddCommand(cmdSnapshotCreate)
+}
+
+func snapshotCreate(cmd *cobra.Command, args []string) error {
+	description, _ := cmd.Flags().GetString("description")
+	includeRAM, _ := cmd.Flags().GetBool("include-ram")
+
+	request := api.CreateSnapshotRequest{
+		Description: description,
+		IncludeRAM:  includeRAM,
+	}
+	if len(args) > 1 {
+		request.Name = args[1]
+	}
+
+	return snapshotTask(http.MethodPost, fmt.Sprintf("/instances/%s/snapshots", args[0]), request,
+		fmt.Sprintf("snapshot instance %s", args[0]),
+		fmt.Sprintf("Taking snapshot of instance %s", args[0]), "Took snapshot")
+}
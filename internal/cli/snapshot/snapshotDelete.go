@@ -0,0 +1,26 @@
+package snapshot
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdSnapshotDelete = &cobra.Command{
+	Use:     "delete <instance id> <name>",
+	Short:   "Delete a snapshot",
+	Example: `$ rc3 snapshot delete 104 before-upgrade`,
+	Args:    cobra.ExactArgs(2),
+	RunE:    snapshotDelete,
+}
+
+func init() {
+	CmdSnapshot.AddCommand(cmdSnapshotDelete)
+}
+
+func snapshotDelete(_ *cobra.Command, args []string) error {
+	return snapshotTask(http.MethodDelete, fmt.Sprintf("/instances/%s/snapshots/%s", args[0], args[1]), nil,
+		fmt.Sprintf("delete snapshot %s", args[1]),
+		fmt.Sprintf("Deleting snapshot of instance %s", args[0]), "Deleted snapshot")
+}
@@ -0,0 +1,52 @@
+package snapshot
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/clintjedwards/rc3/internal/api"
+	"github.com/clintjedwards/rc3/internal/cli/global"
+	"github.com/spf13/cobra"
+)
+
+var cmdSnapshotList = &cobra.Command{
+	Use:     "list <instance id>",
+	Short:   "List the snapshots of an instance",
+	Example: `$ rc3 snapshot list 104`,
+	Args:    cobra.ExactArgs(1),
+	RunE:    snapshotList,
+}
+
+func init() {
+	CmdSnapshot.AddCommand(cmdSnapshotList)
+}
+
+func snapshotList(_ *cobra.Command, args []string) error {
+	cl := global.CLIContext
+
+	var response api.ListSnapshotsResponse
+	err := cl.Request(http.MethodGet, fmt.Sprintf("/instances/%s/snapshots", args[0]), nil, &response)
+	if err != nil {
+		cl.Fmt.PrintErr(fmt.Sprintf("could not list snapshots: %v", err))
+		cl.Fmt.Finish()
+		return err
+	}
+
+	if len(response.Snapshots) == 0 {
+		cl.Fmt.Println("No snapshots found")
+	}
+
+	for _, snapshot := range response.Snapshots {
+		ram := ""
+		if snapshot.IncludesRAM {
+			ram = " (with RAM)"
+		}
+
+		cl.Fmt.Println(fmt.Sprintf("%-40s  %s%s  %s", snapshot.Name,
+			time.Unix(snapshot.Created, 0).Format(time.RFC3339), ram, snapshot.Description))
+	}
+
+	cl.Fmt.Finish()
+	return nil
+}
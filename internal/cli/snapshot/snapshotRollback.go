@@ -0,0 +1,30 @@
+package snapshot
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdSnapshotRollback = &cobra.Command{
+	Use:   "rollback <instance id> <name>",
+	Short: "Roll an instance back to a snapshot",
+	Long: `Roll an instance back to a snapshot.
+
+Everything that happened on the instance since the snapshot was taken is lost. Running instances are stopped while
+they are rolled back and started again afterwards.`,
+	Example: `$ rc3 snapshot rollback 104 before-upgrade`,
+	Args:    cobra.ExactArgs(2),
+	RunE:    snapshotRollback,
+}
+
+func init() {
+	CmdSnapshot.AddCommand(cmdSnapshotRollback)
+}
+
+func snapshotRollback(_ *cobra.Command, args []string) error {
+	return snapshotTask(http.MethodPost, fmt.Sprintf("/instances/%s/snapshots/%s/rollback", args[0], args[1]), nil,
+		fmt.Sprintf("roll back instance %s", args[0]),
+		fmt.Sprintf("Rolling back instance %s", args[0]), "Rolled back to")
+}
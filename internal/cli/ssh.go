@@ -0,0 +1,230 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/clintjedwards/rc3/internal/api"
+	"github.com/clintjedwards/rc3/internal/cli/global"
+	"github.com/spf13/cobra"
+)
+
+var cmdSSH = &cobra.Command{
+	Use:   "ssh <name|id> [-- <ssh arguments>]",
+	Short: "SSH into an instance",
+	Long: `SSH into an instance.
+
+Looks the instance up by name or ID and runs the system's ssh client against its address as the user its image
+logs in as. Instances that can't be reached directly can be reached through a jump host, set with --jump or the
+jump_host setting. Anything after -- is passed to ssh as is.`,
+	Example: `$ rc3 ssh glowing-fern
+$ rc3 ssh 104 --jump me@bastion.example.com
+$ rc3 ssh glowing-fern -- -L 8080:localhost:80
+$ rc3 ssh glowing-fern -- uptime`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: sshInstance,
+}
+
+var cmdSSHConfig = &cobra.Command{
+	Use:   "ssh-config",
+	Short: "Print an SSH config block for each of your instances",
+	Long: `Print an SSH config block for each of your instances.
+
+Each instance gets a Host entry named after it (with a prefix, "rc3-" by default) so plain ssh, scp and editors can
+reach it. Instances that don't have an address yet are listed as comments. Addresses change when instances are
+recreated so regenerate the config now and then.`,
+	Example: `$ rc3 ssh-config > ~/.ssh/config.d/rc3
+$ rc3 ssh-config --prefix "" --jump me@bastion.example.com`,
+	Args: cobra.NoArgs,
+	RunE: sshConfig,
+}
+
+func init() {
+	cmdSSH.Flags().StringP("jump", "J", "", "host to jump through (ex. me@bastion:22); overrides the jump_host setting")
+
+	cmdSSHConfig.Flags().String("jump", "", "host to jump through (ex. me@bastion:22); overrides the jump_host setting")
+	cmdSSHConfig.Flags().String("prefix", "rc3-", "prefix added to each instance's name to make its Host alias")
+
+	// The config is usually redirected into a file, where the pretty format's spinner would end up too.
+	cmdSSHConfig.Flags().String("format", "plain", "output format")
+}
+
+// Returns the address to SSH to, preferring IPv4 since it is more likely to be reachable. Returns an empty string if
+// the instance has no addresses.
+func sshAddress(instance api.Instance) string {
+	switch {
+	case len(instance.IPv4) > 0:
+		return instance.IPv4[0]
+	case len(instance.IPv6) > 0:
+		return instance.IPv6[0]
+	default:
+		return ""
+	}
+}
+
+// Works out the arguments to pass to ssh to log in to an instance. Extra arguments go after the destination; ssh
+// still reads options there and treats anything else as the command to run.
+func sshArgs(instance api.Instance, jumpHost string, extra []string) []string {
+	args := []string{}
+	if jumpHost != "" {
+		args = append(args, "-J", jumpHost)
+	}
+
+	args = append(args, fmt.Sprintf("%s@%s", instance.User, sshAddress(instance)))
+	return append(args, extra...)
+}
+
+// Finds an instance by ID or name. Names are only unique among instances RC3 created, so a name shared with
+// instances made some other way has to be given as an ID instead.
+func matchInstance(instances []api.Instance, nameOrID string) (api.Instance, error) {
+	if id, err := strconv.ParseUint(nameOrID, 10, 64); err == nil {
+		for _, instance := range instances {
+			if instance.ID == id {
+				return instance, nil
+			}
+		}
+	}
+
+	matches := []api.Instance{}
+	for _, instance := range instances {
+		if instance.Name == nameOrID {
+			matches = append(matches, instance)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return api.Instance{}, fmt.Errorf("no instance named %q found", nameOrID)
+	case 1:
+		return matches[0], nil
+	default:
+		ids := []string{}
+		for _, match := range matches {
+			ids = append(ids, strconv.FormatUint(match.ID, 10))
+		}
+		return api.Instance{}, fmt.Errorf("more than one instance is named %q (ids %s); use its id instead", nameOrID,
+			strings.Join(ids, ", "))
+	}
+}
+
+// Builds the ~/.ssh/config entry for an instance. Instances without an address are written as a comment so it is
+// clear why they are missing.
+func sshConfigBlock(instance api.Instance, prefix, jumpHost string) string {
+	address := sshAddress(instance)
+	if address == "" {
+		return fmt.Sprintf("# %s%s (%d) has no address yet; is it running?\n", prefix, instance.Name, instance.ID)
+	}
+
+	block := fmt.Sprintf("Host %s%s\n    HostName %s\n    User %s\n", prefix, instance.Name, address, instance.User)
+	if jumpHost != "" {
+		block += fmt.Sprintf("    ProxyJump %s\n", jumpHost)
+	}
+
+	return block
+}
+
+// The jump host flag wins over the jump_host setting.
+func jumpHost(cmd *cobra.Command) string {
+	jump, _ := cmd.Flags().GetString("jump")
+	if jump != "" {
+		return jump
+	}
+
+	return global.CLIContext.Config.JumpHost
+}
+
+func sshInstance(cmd *cobra.Command, args []string) error {
+	cl := global.CLIContext
+
+	var response api.GetInstancesResponse
+	err := cl.Request(http.MethodGet, "/instances", nil, &response)
+	if err != nil {
+		cl.Fmt.PrintErr(fmt.Sprintf("could not list instances: %v", err))
+		cl.Fmt.Finish()
+		return err
+	}
+
+	instance, err := matchInstance(response.Instances, args[0])
+	if err != nil {
+		cl.Fmt.PrintErr(err.Error())
+		cl.Fmt.Finish()
+		return err
+	}
+
+	if sshAddress(instance) == "" {
+		err := fmt.Errorf("instance %s (%d) is %s and has no address yet", instance.Name, instance.ID, instance.Status)
+		cl.Fmt.PrintErr(fmt.Sprintf("%v; addresses can take a little while to show up after an instance starts, or "+
+			"use the web console at %s", err, cl.URL(fmt.Sprintf("/console/%d", instance.ID))))
+		cl.Fmt.Finish()
+		return err
+	}
+
+	// The spinner has to be gone before ssh takes over the terminal.
+	cl.Fmt.Finish()
+
+	sshPath, err := exec.LookPath("ssh")
+	if err != nil {
+		err = fmt.Errorf("could not find ssh; is an ssh client installed? %w", err)
+		fmt.Fprintln(os.Stderr, err)
+		return err
+	}
+
+	ssh := exec.Command(sshPath, sshArgs(instance, jumpHost(cmd), args[1:])...)
+	ssh.Stdin = os.Stdin
+	ssh.Stdout = os.Stdout
+	ssh.Stderr = os.Stderr
+
+	err = ssh.Run()
+	if err != nil {
+		// ssh has already explained what went wrong; pass its exit status on for scripts.
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+
+		fmt.Fprintf(os.Stderr, "could not run ssh: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+func sshConfig(cmd *cobra.Command, _ []string) error {
+	cl := global.CLIContext
+	prefix, _ := cmd.Flags().GetString("prefix")
+
+	var me api.GetMeResponse
+	err := cl.Request(http.MethodGet, "/me", nil, &me)
+	if err != nil {
+		cl.Fmt.PrintErr(fmt.Sprintf("could not look up who you are logged in as: %v", err))
+		cl.Fmt.Finish()
+		return err
+	}
+
+	var response api.GetInstancesResponse
+	err = cl.Request(http.MethodGet, "/instances", nil, &response)
+	if err != nil {
+		cl.Fmt.PrintErr(fmt.Sprintf("could not list instances: %v", err))
+		cl.Fmt.Finish()
+		return err
+	}
+
+	// The config is written as is, rather than through the formatter, so it can be pasted or redirected into place.
+	cl.Fmt.Finish()
+
+	fmt.Printf("# Generated by `rc3 ssh-config` for %s\n", me.Name)
+	for _, instance := range response.Instances {
+		if instance.Recurser != me.RecurserID {
+			continue
+		}
+
+		fmt.Printf("\n%s", sshConfigBlock(instance, prefix, jumpHost(cmd)))
+	}
+
+	return nil
+}
@@ -0,0 +1,18 @@
+package cli
+
+import (
+	"github.com/clintjedwards/rc3/internal/api"
+	"github.com/spf13/cobra"
+)
+
+var cmdStart = &cobra.Command{
+	Use:     "start <id>",
+	Short:   "Start a stopped instance",
+	Example: `$ rc3 start 104`,
+	Args:    cobra.ExactArgs(1),
+	RunE:    start,
+}
+
+func start(_ *cobra.Command, args []string) error {
+	return powerAction(api.PowerActionStart, args[0], api.PowerActionRequest{}, "Starting", "Started")
+}
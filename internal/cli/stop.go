@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"github.com/clintjedwards/rc3/internal/api"
+	"github.com/spf13/cobra"
+)
+
+var cmdStop = &cobra.Command{
+	Use:   "stop <id>",
+	Short: "Shut down a running instance",
+	Long: `Shut down a running instance.
+
+By default the instance's OS is asked to shut down cleanly. Use --force to stop it immediately instead, which is
+the equivalent of pulling the power cord.`,
+	Example: `$ rc3 stop 104
+$ rc3 stop 104 --timeout 30
+$ rc3 stop 104 --force`,
+	Args: cobra.ExactArgs(1),
+	RunE: stop,
+}
+
+func init() {
+	cmdStop.Flags().Bool("force", false, "stop the instance immediately without waiting for it to shut down")
+	cmdStop.Flags().Uint("timeout", 0, "seconds to wait for a clean shut down; uses the Proxmox default if omitted")
+}
+
+func stop(cmd *cobra.Command, args []string) error {
+	force, _ := cmd.Flags().GetBool("force")
+	timeout, _ := cmd.Flags().GetUint("timeout")
+
+	if force {
+		return powerAction(api.PowerActionStop, args[0], api.PowerActionRequest{}, "Stopping", "Stopped")
+	}
+
+	return powerAction(api.PowerActionShutdown, args[0], api.PowerActionRequest{
+		Timeout: timeout,
+	}, "Shutting down", "Shut down")
+}
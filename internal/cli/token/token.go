@@ -0,0 +1,11 @@
+package token
+
+import "github.com/spf13/cobra"
+
+var CmdToken = &cobra.Command{
+	Use:   "token",
+	Short: "Manage personal API tokens",
+	Long: `Manage personal API tokens.
+
+Personal API tokens let scripts and the CLI talk to RC3 as you without going through the browser login.`,
+}
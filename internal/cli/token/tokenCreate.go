@@ -0,0 +1,50 @@
+package token
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/clintjedwards/rc3/internal/api"
+	"github.com/clintjedwards/rc3/internal/cli/global"
+	"github.com/spf13/cobra"
+)
+
+var cmdTokenCreate = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create a new personal API token",
+	Long: `Create a new personal API token.
+
+The token is only ever shown once, so store it somewhere safe.`,
+	Example: `$ rc3 token create my-script
+$ rc3 token create ci --expires-in 720h`,
+	Args: cobra.ExactArgs(1),
+	RunE: tokenCreate,
+}
+
+func init() {
+	cmdTokenCreate.Flags().String("expires-in", "", "how long the token is valid for (ex. 720h); never expires if omitted")
+	CmdToken.AddCommand(cmdTokenCreate)
+}
+
+func tokenCreate(cmd *cobra.Command, args []string) error {
+	cl := global.CLIContext
+	expiresIn, _ := cmd.Flags().GetString("expires-in")
+
+	cl.Fmt.Print("Creating token")
+
+	var response api.CreateTokenResponse
+	err := cl.Request(http.MethodPost, "/tokens", api.CreateTokenRequest{
+		Name:      args[0],
+		ExpiresIn: expiresIn,
+	}, &response)
+	if err != nil {
+		cl.Fmt.PrintErr(fmt.Sprintf("could not create token: %v", err))
+		cl.Fmt.Finish()
+		return err
+	}
+
+	cl.Fmt.PrintSuccess(fmt.Sprintf("Created token %q (id: %s)", response.Token.Name, response.Token.ID))
+	cl.Fmt.Println(response.Secret)
+	cl.Fmt.Finish()
+	return nil
+}
@@ -0,0 +1,53 @@
+package token
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/clintjedwards/rc3/internal/api"
+	"github.com/clintjedwards/rc3/internal/cli/global"
+	"github.com/spf13/cobra"
+)
+
+var cmdTokenList = &cobra.Command{
+	Use:   "list",
+	Short: "List your personal API tokens",
+	RunE:  tokenList,
+}
+
+func init() {
+	CmdToken.AddCommand(cmdTokenList)
+}
+
+func formatUnix(seconds int64, zeroValue string) string {
+	if seconds == 0 {
+		return zeroValue
+	}
+
+	return time.Unix(seconds, 0).Format(time.RFC3339)
+}
+
+func tokenList(_ *cobra.Command, _ []string) error {
+	cl := global.CLIContext
+
+	var response api.ListTokensResponse
+	err := cl.Request(http.MethodGet, "/tokens", nil, &response)
+	if err != nil {
+		cl.Fmt.PrintErr(fmt.Sprintf("could not list tokens: %v", err))
+		cl.Fmt.Finish()
+		return err
+	}
+
+	if len(response.Tokens) == 0 {
+		cl.Fmt.Println("No tokens found")
+	}
+
+	for _, token := range response.Tokens {
+		cl.Fmt.Println(fmt.Sprintf("%s  %-24s  created %s  expires %s  last used %s", token.ID, token.Name,
+			formatUnix(token.Created, "-"), formatUnix(token.Expires, "never"), formatUnix(token.LastUsed, "never")))
+	}
+
+	cl.Fmt.Finish()
+	return nil
+}
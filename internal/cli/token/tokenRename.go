@@ -0,0 +1,42 @@
+package token
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/clintjedwards/rc3/internal/api"
+	"github.com/clintjedwards/rc3/internal/cli/global"
+	"github.com/spf13/cobra"
+)
+
+var cmdTokenRename = &cobra.Command{
+	Use:     "rename <id> <name>",
+	Short:   "Rename a personal API token",
+	Example: `$ rc3 token rename 3kYd0xq9 laptop`,
+	Args:    cobra.ExactArgs(2),
+	RunE:    tokenRename,
+}
+
+func init() {
+	CmdToken.AddCommand(cmdTokenRename)
+}
+
+func tokenRename(_ *cobra.Command, args []string) error {
+	cl := global.CLIContext
+
+	cl.Fmt.Print("Renaming token")
+
+	var response api.UpdateTokenResponse
+	err := cl.Request(http.MethodPatch, "/tokens/"+args[0], api.UpdateTokenRequest{
+		Name: args[1],
+	}, &response)
+	if err != nil {
+		cl.Fmt.PrintErr(fmt.Sprintf("could not rename token: %v", err))
+		cl.Fmt.Finish()
+		return err
+	}
+
+	cl.Fmt.PrintSuccess(fmt.Sprintf("Renamed token %s to %q", response.Token.ID, response.Token.Name))
+	cl.Fmt.Finish()
+	return nil
+}
@@ -0,0 +1,38 @@
+package token
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/clintjedwards/rc3/internal/cli/global"
+	"github.com/spf13/cobra"
+)
+
+var cmdTokenRevoke = &cobra.Command{
+	Use:     "revoke <id>",
+	Short:   "Revoke a personal API token",
+	Example: `$ rc3 token revoke 3kYd0xq9`,
+	Args:    cobra.ExactArgs(1),
+	RunE:    tokenRevoke,
+}
+
+func init() {
+	CmdToken.AddCommand(cmdTokenRevoke)
+}
+
+func tokenRevoke(_ *cobra.Command, args []string) error {
+	cl := global.CLIContext
+
+	cl.Fmt.Print("Revoking token")
+
+	err := cl.Request(http.MethodDelete, "/tokens/"+args[0], nil, nil)
+	if err != nil {
+		cl.Fmt.PrintErr(fmt.Sprintf("could not revoke token: %v", err))
+		cl.Fmt.Finish()
+		return err
+	}
+
+	cl.Fmt.PrintSuccess(fmt.Sprintf("Revoked token %s", args[0]))
+	cl.Fmt.Finish()
+	return nil
+}
@@ -17,14 +17,41 @@ import (
 type API struct {
 	General     *General     `koanf:"general"`
 	Proxmox     *Proxmox     `koanf:"proxmox"`
+	Auth        *Auth        `koanf:"auth"`
+	Storage     *Storage     `koanf:"storage"`
+	Placement   *Placement   `koanf:"placement"`
+	SSHKeys     *SSHKeys     `koanf:"ssh_keys"`
+	Quotas      *Quotas      `koanf:"quotas"`
+	Expiry      *Expiry      `koanf:"expiry"`
+	Idle        *Idle        `koanf:"idle"`
+	Snapshots   *Snapshots   `koanf:"snapshots"`
+	Backups     *Backups     `koanf:"backups"`
 	Development *Development `koanf:"development"`
 	Server      *Server      `koanf:"server"`
+
+	// The sizes recursers can pick from when creating an instance. Defined as [[sizes]] tables in the config file;
+	// if none are defined DefaultSizes is used.
+	Sizes []Size `koanf:"sizes"`
+
+	// The operating systems recursers can pick from when creating an instance. Defined as [[images]] tables in the
+	// config file; if none are defined a single default image is made from Proxmox.OSTemplate and
+	// Proxmox.VMTemplateID.
+	Images []Image `koanf:"images"`
 }
 
 func DefaultAPIConfig() *API {
 	return &API{
 		General:     DefaultGeneralConfig(),
 		Proxmox:     DefaultProxmoxConfig(),
+		Auth:        DefaultAuthConfig(),
+		Storage:     DefaultStorageConfig(),
+		Placement:   DefaultPlacementConfig(),
+		SSHKeys:     DefaultSSHKeysConfig(),
+		Quotas:      DefaultQuotasConfig(),
+		Expiry:      DefaultExpiryConfig(),
+		Idle:        DefaultIdleConfig(),
+		Snapshots:   DefaultSnapshotsConfig(),
+		Backups:     DefaultBackupsConfig(),
 		Development: DefaultDevelopmentConfig(),
 		Server:      DefaultServerConfig(),
 	}
@@ -53,29 +80,348 @@ type Proxmox struct {
 	// ex. 'local-lvm'
 	InstanceStorage string `koanf:"instance_storage"`
 
-	// The name of the template file that will be used as the OS for containers.
-	// (This by default is expected to be an ubuntu container. Changing the container here to a non-ubuntu os might
-	// conflict with ostype in [`ContainerOptions`]).
+	// The name of the template file that will be used as the OS for containers when no [[images]] are defined. It is
+	// expected to be an ubuntu container; use the image catalog to offer other distributions.
 	//
 	// Full path is needed.
 	//
 	// ex. `local:vztmpl/ubuntu-22.04-standard_22.04-1_amd64.tar.zst`
 	OSTemplate string `koanf:"os_template"`
 
+	// The VMID of the template VM that new VMs are cloned from when no [[images]] are defined. The template must have
+	// cloud-init enabled (a cloud-init drive attached) so that RC3 can inject the user, SSH keys and network settings.
+	//
+	// ex. `9000`
+	VMTemplateID int `koanf:"vm_template_id"`
+
+	// The disk of the template VM that holds the root filesystem. This is the disk that gets grown to the size
+	// requested.
+	VMRootDisk string `koanf:"vm_root_disk"`
+
+	// The user cloud-init creates on new VMs.
+	VMUser string `koanf:"vm_user"`
+
 	// Connect to proxmox using TLS.
 	UseTLS bool `koanf:"use_tls"`
+
+	// The longest we'll wait on a single Proxmox task (ex. stopping or destroying an instance) before giving up on it.
+	// Clones, backups and restores aren't bound by it since they take as long as the disks they copy.
+	TaskTimeout time.Duration `koanf:"task_timeout"`
 }
 
 func DefaultProxmoxConfig() *Proxmox {
 	return &Proxmox{
-		URL:    "http://localhost:8006/api2/json",
-		UseTLS: false,
+		URL:         "http://localhost:8006/api2/json",
+		VMRootDisk:  "scsi0",
+		VMUser:      "recurser",
+		UseTLS:      false,
+		TaskTimeout: mustParseDuration("5m"),
+	}
+}
+
+// Auth controls how recursers log in to RC3. Login is handled by the Recurse Center's OAuth2 provider using the
+// authorization code flow.
+type Auth struct {
+	// The client ID and secret of the OAuth application registered with the Recurse Center.
+	// Applications can be created at: https://www.recurse.com/settings/apps
+	ClientID     string `koanf:"client_id"`
+	ClientSecret string `koanf:"client_secret"`
+
+	// The endpoints of the OAuth2 provider. These only need to be changed when testing against a local stand-in
+	// OAuth server.
+	AuthURL  string `koanf:"auth_url"`
+	TokenURL string `koanf:"token_url"`
+
+	// The endpoint used to look up the profile of the recurser who just logged in.
+	ProfileURL string `koanf:"profile_url"`
+
+	// The URL the provider redirects back to after login. This must match the redirect URI registered with the
+	// OAuth application.
+	//
+	// ex. `https://rc3.recurse.com/auth/callback`
+	RedirectURL string `koanf:"redirect_url"`
+
+	// The secret used to sign session cookies. If left empty a random key is generated on startup, which means
+	// everyone is logged out whenever the service restarts.
+	SessionKey string `koanf:"session_key"`
+
+	// How long a browser session stays valid before the recurser has to log in again.
+	SessionDuration time.Duration `koanf:"session_duration"`
+
+	// Only send session cookies over HTTPS. Should be enabled anywhere other than local development.
+	SecureCookies bool `koanf:"secure_cookies"`
+
+	// Recurser IDs of RC3 admins. Admins can manage every instance, not just their own.
+	Admins []string `koanf:"admins"`
+}
+
+func DefaultAuthConfig() *Auth {
+	return &Auth{
+		AuthURL:         "https://www.recurse.com/oauth/authorize",
+		TokenURL:        "https://www.recurse.com/oauth/token",
+		ProfileURL:      "https://www.recurse.com/api/v1/profiles/me",
+		RedirectURL:     "http://localhost:8080/auth/callback",
+		SessionDuration: mustParseDuration("168h"),
+		SecureCookies:   false,
+	}
+}
+
+// Storage controls where RC3 keeps the state it can't store in Proxmox.
+type Storage struct {
+	// Path to the SQLite database file. It is created, along with its schema, if it doesn't exist, but the directory
+	// it goes in has to exist already.
+	Path string `koanf:"path"`
+}
+
+func DefaultStorageConfig() *Storage {
+	return &Storage{
+		Path: "/var/lib/rc3/rc3.db",
+	}
+}
+
+// Size is an entry in the catalog of instance sizes.
+type Size struct {
+	// What recursers ask for when creating an instance. ex. "small"
+	Name        string `koanf:"name"`
+	Description string `koanf:"description"`
+
+	Cores    int `koanf:"cores"`
+	CPULimit int `koanf:"cpulimit"` // Caps CPU usage to this many cores' worth of time; 0 means no limit.
+	Memory   int `koanf:"memory"`   // Megabytes
+	Swap     int `koanf:"swap"`     // Megabytes; only applies to containers.
+	Disk     int `koanf:"disk"`     // Gigabytes
+
+	// Which kinds of instances ("container", "vm") can be this size. Empty means all of them.
+	Kinds []string `koanf:"kinds"`
+}
+
+func DefaultSizes() []Size {
+	return []Size{
+		{Name: "small", Description: "2 cores, 2 GB of memory", Cores: 2, CPULimit: 2, Memory: 2048, Swap: 512, Disk: 60},
+		{Name: "medium", Description: "2 cores, 4 GB of memory", Cores: 2, CPULimit: 2, Memory: 4096, Swap: 512, Disk: 60},
+		{Name: "large", Description: "4 cores, 8 GB of memory", Cores: 4, CPULimit: 4, Memory: 8192, Swap: 512, Disk: 60},
+	}
+}
+
+// Image is an entry in the catalog of operating systems instances can be created with.
+type Image struct {
+	// What recursers ask for when creating an instance. ex. "debian-12"
+	ID   string `koanf:"id"`
+	Name string `koanf:"name"` // ex. "Debian 12 (Bookworm)"
+
+	// The container template containers are created from. Full path is needed.
+	//
+	// ex. `local:vztmpl/debian-12-standard_12.7-1_amd64.tar.zst`
+	OSTemplate string `koanf:"os_template"`
+
+	// The VMID of the cloud-init enabled template VM that VMs are cloned from.
+	VMTemplateID int `koanf:"vm_template_id"`
+
+	// The Proxmox ostype for containers (ex. "debian", "alpine", "fedora", "archlinux"). Proxmox detects it from the
+	// template if omitted.
+	OSType string `koanf:"os_type"`
+
+	// Which kinds of instances ("container", "vm") can use the image. Defaults to whichever of OSTemplate and
+	// VMTemplateID are set.
+	Kinds []string `koanf:"kinds"`
+
+	// The image used when a recurser doesn't ask for one.
+	Default bool `koanf:"default"`
+
+	// The user cloud-init creates on VMs. Defaults to Proxmox.VMUser.
+	User string `koanf:"user"`
+}
+
+// DefaultImages builds the image catalog used when none is configured out of the single OS template and VM template
+// RC3 used before images could be configured.
+func DefaultImages(proxmox *Proxmox) []Image {
+	return []Image{{
+		ID:           "ubuntu",
+		Name:         "Ubuntu",
+		OSTemplate:   proxmox.OSTemplate,
+		VMTemplateID: proxmox.VMTemplateID,
+		OSType:       "ubuntu",
+		Default:      true,
+	}}
+}
+
+// SSHKeys controls the public keys recursers register to log in to their instances.
+type SSHKeys struct {
+	// Where keys are imported from when importing from GitHub. GitHub serves a user's keys at <url>/<username>.keys.
+	GitHubURL string `koanf:"github_url"`
+
+	// The most keys a single recurser can register.
+	MaxPerRecurser int `koanf:"max_per_recurser"`
+}
+
+func DefaultSSHKeysConfig() *SSHKeys {
+	return &SSHKeys{
+		GitHubURL:      "https://github.com",
+		MaxPerRecurser: 25,
+	}
+}
+
+// Quotas limit how much of the cluster a single recurser can use. These are the defaults for everyone; admins can
+// override them for individual recursers through the API. A limit of 0 means no limit.
+type Quotas struct {
+	// The most instances a recurser can have at once.
+	MaxInstances int `koanf:"max_instances"`
+
+	// The most cores a recurser's instances can have between them.
+	MaxCores int `koanf:"max_cores"`
+
+	// The most memory in MB a recurser's instances can have between them.
+	MaxMemory int `koanf:"max_memory"`
+
+	// The most disk in GB a recurser's instances can have between them.
+	MaxDisk int `koanf:"max_disk"`
+
+	// The most backup storage in GB a recurser's backups can take up between them. A backup's size isn't known until
+	// it is taken so backups are refused once a recurser is at or over the limit rather than before.
+	MaxBackup int `koanf:"max_backup"`
+}
+
+func DefaultQuotasConfig() *Quotas {
+	return &Quotas{
+		MaxInstances: 5,
+		MaxCores:     8,
+		MaxMemory:    16384,
+		MaxDisk:      200,
+		MaxBackup:    100,
+	}
+}
+
+// Expiry controls how long instances live. Once an instance expires it is stopped and, if nobody extends it, deleted
+// a while later. Instances never expire unless default_ttl or max_ttl is set, since turning it on starts deleting
+// things.
+type Expiry struct {
+	// How long instances live when their owner doesn't say. 0 means they never expire.
+	DefaultTTL time.Duration `koanf:"default_ttl"`
+
+	// The longest an instance can be asked to live for, both when it is created and when it is extended. 0 means
+	// there is no maximum.
+	MaxTTL time.Duration `koanf:"max_ttl"`
+
+	// How long before an instance expires its owner is warned.
+	WarnBefore time.Duration `koanf:"warn_before"`
+
+	// How long an expired instance is kept around, stopped, before it is deleted. Gives owners a last chance to
+	// extend it.
+	DeleteAfter time.Duration `koanf:"delete_after"`
+
+	// How often the reaper looks for expiring instances.
+	ReapInterval time.Duration `koanf:"reap_interval"`
+}
+
+func DefaultExpiryConfig() *Expiry {
+	return &Expiry{
+		DefaultTTL:   0,
+		MaxTTL:       0,
+		WarnBefore:   mustParseDuration("24h"),
+		DeleteAfter:  mustParseDuration("72h"),
+		ReapInterval: mustParseDuration("5m"),
+	}
+}
+
+// Idle controls shutting down instances nobody is using. Instances are shut down, never deleted, and pinned instances
+// are left alone.
+type Idle struct {
+	// How long an instance has to stay below both thresholds before it is shut down. 0 turns idle shutdowns off.
+	Window time.Duration `koanf:"window"`
+
+	// CPU use, as a fraction of the instance's cores (0.0 - 1.0), below which an instance counts as idle.
+	CPUThreshold float64 `koanf:"cpu_threshold"`
+
+	// Network traffic, in and out combined, in bytes per second below which an instance counts as idle.
+	NetworkThreshold uint64 `koanf:"network_threshold"`
+
+	// How often instance activity is sampled.
+	SampleInterval time.Duration `koanf:"sample_interval"`
+}
+
+func DefaultIdleConfig() *Idle {
+	return &Idle{
+		Window:           0,
+		CPUThreshold:     0.05,
+		NetworkThreshold: 2048,
+		SampleInterval:   mustParseDuration("5m"),
+	}
+}
+
+// Snapshots controls the snapshots recursers take of their instances.
+type Snapshots struct {
+	// The most snapshots a single recurser can have across all of their instances. 0 means no limit.
+	MaxPerRecurser int `koanf:"max_per_recurser"`
+}
+
+func DefaultSnapshotsConfig() *Snapshots {
+	return &Snapshots{
+		MaxPerRecurser: 10,
+	}
+}
+
+// Backups controls the vzdump backups RC3 takes of instances, either on a schedule set by the instance's owner or on
+// demand. Unlike snapshots, backups are written to separate storage so they survive losing the instance's own storage.
+type Backups struct {
+	// The Proxmox storage backups are written to. It must allow "VZDump backup file" content and should be shared
+	// between nodes so backups can be restored anywhere. Empty turns backups off.
+	//
+	// ex. 'backups-nfs'
+	Storage string `koanf:"storage"`
+
+	// How vzdump backs up running instances: "snapshot" (no downtime), "suspend" or "stop".
+	Mode string `koanf:"mode"`
+
+	// How backups are compressed: "zstd", "lzo", "gzip" or "0" for no compression.
+	Compress string `koanf:"compress"`
+
+	// The most scheduled backups a single instance's policy can keep.
+	MaxRetention int `koanf:"max_retention"`
+
+	// How often the scheduler looks for backups that are due.
+	ScheduleInterval time.Duration `koanf:"schedule_interval"`
+}
+
+func DefaultBackupsConfig() *Backups {
+	return &Backups{
+		Mode:             "snapshot",
+		Compress:         "zstd",
+		MaxRetention:     14,
+		ScheduleInterval: mustParseDuration("15m"),
+	}
+}
+
+// Placement controls which node new instances are created on.
+type Placement struct {
+	// How nodes are chosen:
+	//   - "spread" puts each instance on the node with the most headroom, keeping load even across the cluster.
+	//   - "pack" fills up the busiest node that still has room before moving on to the next.
+	//   - "pinned" always uses PinnedNode.
+	Strategy string `koanf:"strategy"`
+
+	// The node every instance is created on when using the "pinned" strategy.
+	PinnedNode string `koanf:"pinned_node"`
+
+	// Nodes that should not receive new instances (ex. while they're being upgraded). Instances already on them are
+	// left alone.
+	MaintenanceNodes []string `koanf:"maintenance_nodes"`
+}
+
+func DefaultPlacementConfig() *Placement {
+	return &Placement{
+		Strategy: "spread",
 	}
 }
 
 type Development struct {
 	PrettyLogging bool `koanf:"pretty_logging"`
-	BypassAuth    bool `koanf:"bypass_auth"`
+
+	// Skip login entirely and treat every request as if it came from the fake recurser below.
+	BypassAuth bool `koanf:"bypass_auth"`
+
+	// The identity injected into every request when auth is bypassed.
+	FakeRecurserID   string `koanf:"fake_recurser_id"`
+	FakeRecurserName string `koanf:"fake_recurser_name"`
 
 	// Instead of having to recompile the static files into the binary during development for every change
 	// instead uses another implementation of the fileserver to easily serve files from local disk.
@@ -86,6 +432,8 @@ func DefaultDevelopmentConfig() *Development {
 	return &Development{
 		PrettyLogging:             true,
 		BypassAuth:                true,
+		FakeRecurserID:            "0",
+		FakeRecurserName:          "Development Recurser",
 		LoadFrontendFilesFromDisk: true,
 	}
 }
@@ -97,6 +445,14 @@ type Server struct {
 
 	// How long the service should wait on in-progress connections before hard closing everything out.
 	ShutdownTimeout time.Duration `koanf:"shutdown_timeout"`
+
+	// The longest a request made with ?wait=true is held open waiting on its task. Requests that hit this get back
+	// the task as it stands and can poll it from there.
+	MaxTaskWait time.Duration `koanf:"max_task_wait"`
+
+	// How long instance IP addresses are remembered before being looked up again. Looking them up means a call to
+	// every running instance (and waiting on the guest agent for VMs), so listing instances would be slow without it.
+	AddressCacheTTL time.Duration `koanf:"address_cache_ttl"`
 }
 
 // DefaultServerConfig returns a pre-populated configuration struct that is used as the base for super imposing user configuration
@@ -105,6 +461,8 @@ func DefaultServerConfig() *Server {
 	return &Server{
 		Host:            "0.0.0.0:8080",
 		ShutdownTimeout: mustParseDuration("15s"),
+		MaxTaskWait:     mustParseDuration("2m"),
+		AddressCacheTTL: mustParseDuration("30s"),
 	}
 }
 
@@ -163,6 +521,16 @@ func InitAPIConfig(userDefinedPath string, loadDefaults bool) (*API, error) {
 		return nil, err
 	}
 
+	// Lists would be merged element by element with the user's, so catalog defaults are only filled in if the user
+	// didn't define any.
+	if loadDefaults && len(config.Sizes) == 0 {
+		config.Sizes = DefaultSizes()
+	}
+
+	if loadDefaults && len(config.Images) == 0 {
+		config.Images = DefaultImages(config.Proxmox)
+	}
+
 	return config, nil
 }
 
@@ -170,6 +538,15 @@ func GetAPIEnvVars() []string {
 	api := API{
 		General:     &General{},
 		Proxmox:     &Proxmox{},
+		Auth:        &Auth{},
+		Storage:     &Storage{},
+		Placement:   &Placement{},
+		SSHKeys:     &SSHKeys{},
+		Quotas:      &Quotas{},
+		Expiry:      &Expiry{},
+		Idle:        &Idle{},
+		Snapshots:   &Snapshots{},
+		Backups:     &Backups{},
 		Development: &Development{},
 		Server:      &Server{},
 	}
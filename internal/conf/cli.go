@@ -2,6 +2,7 @@ package conf
 
 import (
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 
@@ -18,6 +19,10 @@ type CLI struct {
 	Host    string `koanf:"host"`
 	NoColor bool   `koanf:"no_color"`
 	Token   string `koanf:"token"`
+
+	// A host (ex. "user@bastion.example.com:22") to jump through when SSHing into instances that aren't directly
+	// reachable. Passed to ssh as -J/ProxyJump.
+	JumpHost string `koanf:"jump_host"`
 }
 
 // DefaultCLIConfig returns a pre-populated configuration struct that is used as the base for super imposing user configuration
@@ -86,6 +91,55 @@ func InitCLIConfig(flagPath string, loadDefaults bool) (*CLI, error) {
 	return config, nil
 }
 
+// SaveCLIConfigValue persists a single setting to the user's CLI config file, keeping any other settings already in
+// it. The first config file found is updated; if none exists a new one is created at ~/.rc3.toml.
+//
+// It returns the path of the file that was written.
+func SaveCLIConfigValue(key string, value any) (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	path := searchFilePaths(possibleConfigPaths(homeDir, "")...)
+
+	envPath := os.Getenv("RC3_CLI_CONFIG_PATH")
+	if envPath != "" {
+		path = envPath
+	}
+
+	if path == "" {
+		path = filepath.Join(homeDir, ".rc3.toml")
+	}
+
+	configParser := koanf.New(".")
+
+	if _, err := os.Stat(path); err == nil {
+		err := configParser.Load(file.Provider(path), toml.Parser())
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = configParser.Set(key, value)
+	if err != nil {
+		return "", err
+	}
+
+	contents, err := configParser.Marshal(toml.Parser())
+	if err != nil {
+		return "", err
+	}
+
+	// The config can contain secrets like the API token so keep it private to the user.
+	err = os.WriteFile(path, contents, 0o600)
+	if err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
 func GetCLIEnvVars() []string {
 	vars := getEnvVarsFromStruct("RC3_CLI_", structs.Fields(CLI{}))
 	sort.Strings(vars)
@@ -64,6 +64,12 @@ func getEnvVarsFromStruct(prefix string, fields []*structs.Field) []string {
 
 	for _, field := range fields {
 		tag := field.Tag("koanf")
+
+		// Lists of tables (ex. [[sizes]]) can only be set in the config file.
+		if field.Kind() == reflect.Slice && reflect.TypeOf(field.Value()).Elem().Kind() == reflect.Struct {
+			continue
+		}
+
 		if field.Kind() == reflect.Pointer {
 			output = append(output, getEnvVarsFromStruct(strings.ToUpper(prefix+tag+"__"), field.Fields())...)
 			continue
@@ -0,0 +1,30 @@
+// Package frontend holds the pages RC3 serves to browsers alongside the API.
+package frontend
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed console.html
+var consolePage []byte
+
+// Third party files the pages need, served by RC3 itself so a compromised CDN can't run code in the console.
+//
+//go:embed public
+var public embed.FS
+
+// Console serves the web console page. The page works out which instance it is for from its URL (/console/<id>)
+// and talks to the API for everything else, so it is the same for every instance and doesn't need any auth of its
+// own; recursers who aren't logged in are sent to log in first.
+func Console(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(consolePage)
+}
+
+// Assets serves the files under public at /console/assets/.
+func Assets() http.Handler {
+	assets, _ := fs.Sub(public, "public") // Only fails if the path is invalid, which it isn't.
+	return http.StripPrefix("/console/assets/", http.FileServer(http.FS(assets)))
+}
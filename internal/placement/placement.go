@@ -0,0 +1,180 @@
+// Package placement decides which node in the Proxmox cluster a new instance should be created on.
+//
+// Nodes that are offline, in maintenance or too full to fit the instance are never chosen. Out of the rest, a
+// Strategy picks one; most strategies do so by scoring each node on its free memory, CPU load, storage headroom and
+// how many instances it already runs.
+package placement
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrNoCapacity is returned when no node is able to take the instance.
+var ErrNoCapacity = errors.New("no node has room for the instance")
+
+// Node is a snapshot of a node's state at the time an instance is being placed.
+type Node struct {
+	Name        string
+	Online      bool
+	Maintenance bool // Nodes in maintenance don't take new instances.
+
+	CPU float64 // Current CPU load as a fraction of the node's total CPU (0.0 - 1.0).
+
+	MemoryUsed  uint64 // Bytes
+	MemoryTotal uint64 // Bytes
+
+	StorageUsed  uint64 // Bytes used on the storage instances are created on.
+	StorageTotal uint64 // Bytes
+
+	Instances int // How many containers and VMs are already on the node.
+}
+
+func (n Node) memoryFree() uint64 {
+	if n.MemoryUsed > n.MemoryTotal {
+		return 0
+	}
+	return n.MemoryTotal - n.MemoryUsed
+}
+
+func (n Node) storageFree() uint64 {
+	if n.StorageUsed > n.StorageTotal {
+		return 0
+	}
+	return n.StorageTotal - n.StorageUsed
+}
+
+// Requirements is what the instance being placed needs from its node.
+type Requirements struct {
+	MemoryBytes uint64
+	DiskBytes   uint64
+}
+
+// Strategy chooses a node for an instance out of the nodes that are able to take it. Candidates are sorted by name
+// and there is always at least one.
+type Strategy interface {
+	Pick(candidates []Node, requirements Requirements) (Node, error)
+}
+
+const (
+	StrategySpread = "spread"
+	StrategyPack   = "pack"
+	StrategyPinned = "pinned"
+)
+
+// NewStrategy returns the strategy with the given name. The pinned node is only used by the pinned strategy.
+func NewStrategy(name, pinnedNode string) (Strategy, error) {
+	switch strings.ToLower(name) {
+	case StrategySpread:
+		return Spread{}, nil
+	case StrategyPack:
+		return Pack{}, nil
+	case StrategyPinned:
+		if pinnedNode == "" {
+			return nil, fmt.Errorf("the pinned strategy requires a pinned node")
+		}
+		return Pinned{Node: pinnedNode}, nil
+	default:
+		return nil, fmt.Errorf("unknown placement strategy %q; must be one of %q, %q or %q", name, StrategySpread,
+			StrategyPack, StrategyPinned)
+	}
+}
+
+// Place picks the node an instance should be created on using the given strategy.
+func Place(strategy Strategy, nodes []Node, requirements Requirements) (Node, error) {
+	candidates := []Node{}
+	for _, node := range nodes {
+		if fits(node, requirements) {
+			candidates = append(candidates, node)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return Node{}, ErrNoCapacity
+	}
+
+	// Sorting makes ties go the same way every time.
+	slices.SortFunc(candidates, func(a, b Node) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return strategy.Pick(candidates, requirements)
+}
+
+func fits(node Node, requirements Requirements) bool {
+	return node.Online && !node.Maintenance &&
+		node.memoryFree() >= requirements.MemoryBytes &&
+		node.storageFree() >= requirements.DiskBytes
+}
+
+// How much each factor counts towards a node's score. They add up to 1 so scores fall between 0 and 1.
+const (
+	memoryWeight    = 0.4
+	cpuWeight       = 0.2
+	storageWeight   = 0.2
+	instancesWeight = 0.2
+)
+
+// Scores how much headroom a node would have left after taking the instance. Higher is emptier.
+func score(node Node, requirements Requirements) float64 {
+	memory := 0.0
+	if node.MemoryTotal > 0 {
+		memory = float64(node.memoryFree()-requirements.MemoryBytes) / float64(node.MemoryTotal)
+	}
+
+	storage := 0.0
+	if node.StorageTotal > 0 {
+		storage = float64(node.storageFree()-requirements.DiskBytes) / float64(node.StorageTotal)
+	}
+
+	cpu := 1 - min(max(node.CPU, 0), 1)
+	instances := 1 / float64(1+node.Instances)
+
+	return memoryWeight*memory + cpuWeight*cpu + storageWeight*storage + instancesWeight*instances
+}
+
+// Spread puts instances on the node with the most headroom so load stays even across the cluster.
+type Spread struct{}
+
+func (Spread) Pick(candidates []Node, requirements Requirements) (Node, error) {
+	best := candidates[0]
+	for _, node := range candidates[1:] {
+		if score(node, requirements) > score(best, requirements) {
+			best = node
+		}
+	}
+
+	return best, nil
+}
+
+// Pack puts instances on the busiest node that still has room, keeping other nodes free for large instances (or
+// powered down).
+type Pack struct{}
+
+func (Pack) Pick(candidates []Node, requirements Requirements) (Node, error) {
+	best := candidates[0]
+	for _, node := range candidates[1:] {
+		if score(node, requirements) < score(best, requirements) {
+			best = node
+		}
+	}
+
+	return best, nil
+}
+
+// Pinned always uses the same node, failing if it can't take the instance.
+type Pinned struct {
+	Node string
+}
+
+func (p Pinned) Pick(candidates []Node, _ Requirements) (Node, error) {
+	for _, node := range candidates {
+		if node.Name == p.Node {
+			return node, nil
+		}
+	}
+
+	return Node{}, fmt.Errorf("%w: pinned node %q is offline, in maintenance or full", ErrNoCapacity, p.Node)
+}
@@ -0,0 +1,238 @@
+package placement
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+const gib = 1 << 30
+
+// Builds an online node with the given free memory and storage out of 100 GiB of each, with no load or instances.
+func node(name string, memoryFreeGiB, storageFreeGiB uint64) Node {
+	return Node{
+		Name:         name,
+		Online:       true,
+		MemoryUsed:   (100 - memoryFreeGiB) * gib,
+		MemoryTotal:  100 * gib,
+		StorageUsed:  (100 - storageFreeGiB) * gib,
+		StorageTotal: 100 * gib,
+	}
+}
+
+func TestPlace(t *testing.T) {
+	offline := node("offline", 90, 90)
+	offline.Online = false
+
+	maintenance := node("maintenance", 90, 90)
+	maintenance.Maintenance = true
+
+	busy := node("busy", 50, 50)
+	busy.CPU = 0.9
+	busy.Instances = 9
+
+	requirements := Requirements{MemoryBytes: 8 * gib, DiskBytes: 20 * gib}
+
+	tests := map[string]struct {
+		strategy Strategy
+		nodes    []Node
+		want     string
+		wantErr  error
+	}{
+		"spread picks the emptiest node": {
+			strategy: Spread{},
+			nodes:    []Node{node("a", 30, 30), node("b", 80, 80), node("c", 50, 50)},
+			want:     "b",
+		},
+		"pack picks the fullest node with room": {
+			strategy: Pack{},
+			nodes:    []Node{node("a", 30, 30), node("b", 80, 80), node("c", 50, 50)},
+			want:     "a",
+		},
+		"spread skips offline and maintenance nodes": {
+			strategy: Spread{},
+			nodes:    []Node{offline, maintenance, busy},
+			want:     "busy",
+		},
+		"pack skips nodes without enough memory": {
+			strategy: Pack{},
+			nodes:    []Node{node("a", 4, 90), node("b", 60, 60)},
+			want:     "b",
+		},
+		"pack skips nodes without enough storage": {
+			strategy: Pack{},
+			nodes:    []Node{node("a", 90, 10), node("b", 60, 60)},
+			want:     "b",
+		},
+		"exact fit is allowed": {
+			strategy: Spread{},
+			nodes:    []Node{node("a", 8, 20)},
+			want:     "a",
+		},
+		"ties go to the first node by name": {
+			strategy: Spread{},
+			nodes:    []Node{node("c", 50, 50), node("a", 50, 50), node("b", 50, 50)},
+			want:     "a",
+		},
+		"pinned uses its node even when others are emptier": {
+			strategy: Pinned{Node: "a"},
+			nodes:    []Node{node("a", 30, 30), node("b", 80, 80)},
+			want:     "a",
+		},
+		"pinned node offline": {
+			strategy: Pinned{Node: "offline"},
+			nodes:    []Node{offline, node("b", 80, 80)},
+			wantErr:  ErrNoCapacity,
+		},
+		"pinned node in maintenance": {
+			strategy: Pinned{Node: "maintenance"},
+			nodes:    []Node{maintenance, node("b", 80, 80)},
+			wantErr:  ErrNoCapacity,
+		},
+		"pinned node missing": {
+			strategy: Pinned{Node: "missing"},
+			nodes:    []Node{node("a", 80, 80)},
+			wantErr:  ErrNoCapacity,
+		},
+		"no nodes": {
+			strategy: Spread{},
+			nodes:    []Node{},
+			wantErr:  ErrNoCapacity,
+		},
+		"every node full or unavailable": {
+			strategy: Pack{},
+			nodes:    []Node{offline, maintenance, node("a", 4, 90), node("b", 90, 10)},
+			wantErr:  ErrNoCapacity,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := Place(test.strategy, test.nodes, requirements)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("got error %v; want %v", err, test.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("got error %v; want node %q", err, test.want)
+			}
+
+			if got.Name != test.want {
+				t.Errorf("got node %q; want %q", got.Name, test.want)
+			}
+		})
+	}
+}
+
+func TestScore(t *testing.T) {
+	n := node("a", 60, 40)
+	n.CPU = 0.25
+	n.Instances = 3
+
+	// Memory: (60-10)/100, CPU: 1-0.25, storage: (40-20)/100, instances: 1/(1+3).
+	got := score(n, Requirements{MemoryBytes: 10 * gib, DiskBytes: 20 * gib})
+	want := 0.4*0.5 + 0.2*0.75 + 0.2*0.2 + 0.2*0.25
+	if math.Abs(got-want) > 1e-9 {
+		t.Errorf("got score %v; want %v", got, want)
+	}
+
+	// CPU load is clamped to 0.0 - 1.0.
+	n.CPU = 1.5
+	overloaded := score(n, Requirements{})
+	n.CPU = 1
+	if loaded := score(n, Requirements{}); overloaded != loaded {
+		t.Errorf("got score %v for load over 1; want %v", overloaded, loaded)
+	}
+}
+
+// Memory counts for twice as much as each of the other factors, so a node with lots of free memory is preferred over
+// one that only wins on CPU, storage or instance count, but not over one that wins on all three.
+func TestScoreWeights(t *testing.T) {
+	roomy := node("roomy", 90, 50)
+	roomy.CPU = 0.5
+	roomy.Instances = 4
+
+	idle := node("idle", 50, 50)
+
+	tests := map[string]struct {
+		other Node
+		want  string
+	}{
+		"memory beats cpu": {
+			other: func() Node {
+				n := node("other", 50, 50)
+				n.Instances = 4
+				return n
+			}(),
+			want: "roomy",
+		},
+		"memory beats storage": {
+			other: func() Node {
+				n := node("other", 50, 100)
+				n.CPU = 0.5
+				n.Instances = 4
+				return n
+			}(),
+			want: "roomy",
+		},
+		"cpu, storage and instances beat memory": {
+			other: func() Node {
+				n := idle
+				n.StorageUsed = 0
+				return n
+			}(),
+			want: "idle",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := Place(Spread{}, []Node{roomy, test.other}, Requirements{})
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+
+			if got.Name != test.want {
+				t.Errorf("got node %q; want %q (scores %v and %v)", got.Name, test.want,
+					score(roomy, Requirements{}), score(test.other, Requirements{}))
+			}
+		})
+	}
+}
+
+func TestNewStrategy(t *testing.T) {
+	tests := map[string]struct {
+		name, pinnedNode string
+		want             Strategy
+		wantErr          bool
+	}{
+		"spread":              {name: "spread", want: Spread{}},
+		"pack ignores case":   {name: "PACK", want: Pack{}},
+		"pinned":              {name: "pinned", pinnedNode: "pve1", want: Pinned{Node: "pve1"}},
+		"pinned without node": {name: "pinned", wantErr: true},
+		"unknown":             {name: "random", wantErr: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := NewStrategy(test.name, test.pinnedNode)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("got strategy %#v; want an error", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+
+			if got != test.want {
+				t.Errorf("got strategy %#v; want %#v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package storage
+
+// BackupPolicy is a schedule for backing up a single instance.
+type BackupPolicy struct {
+	InstanceID uint64
+	RecurserID string // The owner of the instance.
+	Schedule   string // "daily" or "weekly"
+	Retention  int    // How many scheduled backups to keep.
+	LastRun    int64  // Unix seconds; 0 means the policy has never run.
+	Created    int64  // Unix seconds
+}
+
+const backupPolicyColumns = `instance_id, recurser_id, schedule, retention, last_run, created`
+
+func scanBackupPolicy(row interface{ Scan(...any) error }) (*BackupPolicy, error) {
+	var policy BackupPolicy
+	err := row.Scan(&policy.InstanceID, &policy.RecurserID, &policy.Schedule, &policy.Retention, &policy.LastRun,
+		&policy.Created)
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	return &policy, nil
+}
+
+// PutBackupPolicy sets an instance's backup policy, replacing any policy it already had. When it last ran is kept
+// so changing a policy doesn't cause an extra backup.
+func (db *DB) PutBackupPolicy(policy *BackupPolicy) error {
+	_, err := db.db.Exec(`INSERT INTO backup_policies (`+backupPolicyColumns+`) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (instance_id) DO UPDATE SET recurser_id = excluded.recurser_id, schedule = excluded.schedule,
+		retention = excluded.retention`,
+		policy.InstanceID, policy.RecurserID, policy.Schedule, policy.Retention, policy.LastRun, policy.Created)
+	return mapError(err)
+}
+
+func (db *DB) GetBackupPolicy(instanceID uint64) (*BackupPolicy, error) {
+	return scanBackupPolicy(db.db.QueryRow(`SELECT `+backupPolicyColumns+` FROM backup_policies
+		WHERE instance_id = ?`, instanceID))
+}
+
+// ListBackupPolicies returns every backup policy, ordered by instance ID.
+func (db *DB) ListBackupPolicies() ([]BackupPolicy, error) {
+	rows, err := db.db.Query(`SELECT ` + backupPolicyColumns + ` FROM backup_policies ORDER BY instance_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []BackupPolicy{}
+	for rows.Next() {
+		policy, err := scanBackupPolicy(rows)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, *policy)
+	}
+
+	return policies, rows.Err()
+}
+
+// UpdateBackupPolicyLastRun records when a policy last started a backup.
+func (db *DB) UpdateBackupPolicyLastRun(instanceID uint64, lastRun int64) error {
+	result, err := db.db.Exec(`UPDATE backup_policies SET last_run = ? WHERE instance_id = ?`, lastRun, instanceID)
+	if err != nil {
+		return mapError(err)
+	}
+
+	return checkAffected(result)
+}
+
+func (db *DB) DeleteBackupPolicy(instanceID uint64) error {
+	result, err := db.db.Exec(`DELETE FROM backup_policies WHERE instance_id = ?`, instanceID)
+	if err != nil {
+		return mapError(err)
+	}
+
+	return checkAffected(result)
+}
+
+// Backup is a vzdump backup of an instance taken through RC3.
+type Backup struct {
+	ID           int64
+	InstanceID   uint64
+	RecurserID   string // The owner of the instance, who the backup counts against.
+	InstanceName string
+	Kind         string
+	Size         string
+	Node         string // The node the backup was taken on.
+	Storage      string
+	Volume       string // The Proxmox volume ID of the backup archive.
+	Bytes        uint64
+	Scheduled    bool  // Whether a backup policy took the backup rather than its owner.
+	Created      int64 // Unix seconds
+}
+
+const backupColumns = `id, instance_id, recurser_id, instance_name, kind, size, node, storage, volume, bytes,
+	scheduled, created`
+
+func scanBackup(row interface{ Scan(...any) error }) (*Backup, error) {
+	var backup Backup
+	err := row.Scan(&backup.ID, &backup.InstanceID, &backup.RecurserID, &backup.InstanceName, &backup.Kind,
+		&backup.Size, &backup.Node, &backup.Storage, &backup.Volume, &backup.Bytes, &backup.Scheduled,
+		&backup.Created)
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	return &backup, nil
+}
+
+// InsertBackup records a new backup, filling in its ID.
+func (db *DB) InsertBackup(backup *Backup) error {
+	result, err := db.db.Exec(`INSERT INTO backups (instance_id, recurser_id, instance_name, kind, size, node,
+		storage, volume, bytes, scheduled, created) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		backup.InstanceID, backup.RecurserID, backup.InstanceName, backup.Kind, backup.Size, backup.Node,
+		backup.Storage, backup.Volume, backup.Bytes, backup.Scheduled, backup.Created)
+	if err != nil {
+		return mapError(err)
+	}
+
+	backup.ID, err = result.LastInsertId()
+	return err
+}
+
+func (db *DB) GetBackup(id int64) (*Backup, error) {
+	return scanBackup(db.db.QueryRow(`SELECT `+backupColumns+` FROM backups WHERE id = ?`, id))
+}
+
+func (db *DB) listBackups(query string, args ...any) ([]Backup, error) {
+	rows, err := db.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	backups := []Backup{}
+	for rows.Next() {
+		backup, err := scanBackup(rows)
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, *backup)
+	}
+
+	return backups, rows.Err()
+}
+
+// ListInstanceBackups returns the backups of an instance taken at or after since, newest first. Passing the time the
+// instance was created leaves out backups of earlier instances that had the same ID.
+func (db *DB) ListInstanceBackups(instanceID uint64, since int64) ([]Backup, error) {
+	return db.listBackups(`SELECT `+backupColumns+` FROM backups WHERE instance_id = ? AND created >= ?
+		ORDER BY created DESC, id DESC`, instanceID, since)
+}
+
+// ListRecurserBackups returns every backup that counts against a recurser, newest first.
+func (db *DB) ListRecurserBackups(recurserID string) ([]Backup, error) {
+	return db.listBackups(`SELECT `+backupColumns+` FROM backups WHERE recurser_id = ?
+		ORDER BY created DESC, id DESC`, recurserID)
+}
+
+// SumBackupBytes returns how much backup storage a recurser's backups take up.
+func (db *DB) SumBackupBytes(recurserID string) (uint64, error) {
+	var total uint64
+	err := db.db.QueryRow(`SELECT COALESCE(SUM(bytes), 0) FROM backups WHERE recurser_id = ?`, recurserID).
+		Scan(&total)
+	if err != nil {
+		return 0, mapError(err)
+	}
+
+	return total, nil
+}
+
+// BackupVolumeRecorded reports whether a backup archive is already recorded.
+func (db *DB) BackupVolumeRecorded(volume string) (bool, error) {
+	var count int
+	err := db.db.QueryRow(`SELECT COUNT(*) FROM backups WHERE volume = ?`, volume).Scan(&count)
+	if err != nil {
+		return false, mapError(err)
+	}
+
+	return count > 0, nil
+}
+
+func (db *DB) DeleteBackup(id int64) error {
+	result, err := db.db.Exec(`DELETE FROM backups WHERE id = ?`, id)
+	if err != nil {
+		return mapError(err)
+	}
+
+	return checkAffected(result)
+}
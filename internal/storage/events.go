@@ -0,0 +1,67 @@
+package storage
+
+// Event is something that happened to an instance that its owner should know about.
+type Event struct {
+	ID         int64
+	InstanceID uint64
+	RecurserID string // The instance's owner.
+	Kind       string
+	Message    string
+	Created    int64 // Unix seconds
+}
+
+const eventColumns = `id, instance_id, recurser_id, kind, message, created`
+
+func scanEvent(row interface{ Scan(...any) error }) (*Event, error) {
+	var event Event
+	err := row.Scan(&event.ID, &event.InstanceID, &event.RecurserID, &event.Kind, &event.Message, &event.Created)
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	return &event, nil
+}
+
+// InsertEvent records a new event, filling in its ID.
+func (db *DB) InsertEvent(event *Event) error {
+	result, err := db.db.Exec(`INSERT INTO instance_events (instance_id, recurser_id, kind, message, created)
+		VALUES (?, ?, ?, ?, ?)`, event.InstanceID, event.RecurserID, event.Kind, event.Message, event.Created)
+	if err != nil {
+		return mapError(err)
+	}
+
+	event.ID, err = result.LastInsertId()
+	return err
+}
+
+func (db *DB) listEvents(query string, args ...any) ([]Event, error) {
+	rows, err := db.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *event)
+	}
+
+	return events, rows.Err()
+}
+
+// ListInstanceEvents returns the events for an instance that happened at or after since, newest first. Proxmox
+// reuses IDs so since should be when the current instance with that ID was created.
+func (db *DB) ListInstanceEvents(instanceID uint64, since int64) ([]Event, error) {
+	return db.listEvents(`SELECT `+eventColumns+` FROM instance_events WHERE instance_id = ? AND created >= ?
+		ORDER BY id DESC`, instanceID, since)
+}
+
+// ListRecurserEvents returns the most recent events for every instance a recurser owns (or owned), newest first.
+func (db *DB) ListRecurserEvents(recurserID string, limit int) ([]Event, error) {
+	return db.listEvents(`SELECT `+eventColumns+` FROM instance_events WHERE recurser_id = ?
+		ORDER BY id DESC LIMIT ?`, recurserID, limit)
+}
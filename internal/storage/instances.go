@@ -0,0 +1,126 @@
+package storage
+
+// Instance is the metadata RC3 keeps about each container or VM it creates, keyed by the Proxmox VMID.
+type Instance struct {
+	ID         uint64
+	RecurserID string
+	Name       string
+	Kind       string
+	Size       string
+	Template   string // The OS template or VM template the instance was created from.
+	Node       string // The node the instance was created on; instances may have since migrated.
+	Created    int64  // Unix seconds
+	Settings   string // JSON encoded copy of the settings requested at creation.
+	Expires    int64  // Unix seconds; 0 means the instance never expires.
+
+	// How far the reaper has got with cleaning up the instance once it is close to expiring. Empty until then.
+	ExpiryStage string
+
+	// Pinned instances are always-on and are never shut down for being idle.
+	Pinned bool
+}
+
+const instanceColumns = `id, recurser_id, name, kind, size, template, node, created, settings, expires, expiry_stage,
+	pinned`
+
+func scanInstance(row interface{ Scan(...any) error }) (*Instance, error) {
+	var instance Instance
+	err := row.Scan(&instance.ID, &instance.RecurserID, &instance.Name, &instance.Kind, &instance.Size,
+		&instance.Template, &instance.Node, &instance.Created, &instance.Settings, &instance.Expires,
+		&instance.ExpiryStage, &instance.Pinned)
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	return &instance, nil
+}
+
+func (db *DB) InsertInstance(instance *Instance) error {
+	_, err := db.db.Exec(`INSERT INTO instances (`+instanceColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		instance.ID, instance.RecurserID, instance.Name, instance.Kind, instance.Size, instance.Template,
+		instance.Node, instance.Created, instance.Settings, instance.Expires, instance.ExpiryStage, instance.Pinned)
+	return mapError(err)
+}
+
+func (db *DB) GetInstance(id uint64) (*Instance, error) {
+	return scanInstance(db.db.QueryRow(`SELECT `+instanceColumns+` FROM instances WHERE id = ?`, id))
+}
+
+func (db *DB) GetInstanceByName(name string) (*Instance, error) {
+	return scanInstance(db.db.QueryRow(`SELECT `+instanceColumns+` FROM instances WHERE name = ?`, name))
+}
+
+func (db *DB) listInstances(query string, args ...any) ([]Instance, error) {
+	rows, err := db.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	instances := []Instance{}
+	for rows.Next() {
+		instance, err := scanInstance(rows)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, *instance)
+	}
+
+	return instances, rows.Err()
+}
+
+// ListInstances returns every instance RC3 knows about, ordered by ID.
+func (db *DB) ListInstances() ([]Instance, error) {
+	return db.listInstances(`SELECT ` + instanceColumns + ` FROM instances ORDER BY id`)
+}
+
+// ListInstancesByRecurser returns every instance owned by a recurser, ordered by ID.
+func (db *DB) ListInstancesByRecurser(recurserID string) ([]Instance, error) {
+	return db.listInstances(`SELECT `+instanceColumns+` FROM instances WHERE recurser_id = ? ORDER BY id`, recurserID)
+}
+
+// ListInstancesExpiringBefore returns every instance that expires at or before the given time, soonest first.
+// Instances that never expire aren't included.
+func (db *DB) ListInstancesExpiringBefore(before int64) ([]Instance, error) {
+	return db.listInstances(`SELECT `+instanceColumns+` FROM instances WHERE expires != 0 AND expires <= ?
+		ORDER BY expires`, before)
+}
+
+// UpdateInstanceExpiry changes when an instance expires and how far the reaper has got with it.
+func (db *DB) UpdateInstanceExpiry(id uint64, expires int64, stage string) error {
+	result, err := db.db.Exec(`UPDATE instances SET expires = ?, expiry_stage = ? WHERE id = ?`, expires, stage, id)
+	if err != nil {
+		return mapError(err)
+	}
+
+	return checkAffected(result)
+}
+
+// UpdateInstanceSize changes the size an instance is recorded (and counted against its owner's quota) as.
+func (db *DB) UpdateInstanceSize(id uint64, size string) error {
+	result, err := db.db.Exec(`UPDATE instances SET size = ? WHERE id = ?`, size, id)
+	if err != nil {
+		return mapError(err)
+	}
+
+	return checkAffected(result)
+}
+
+// SetInstancePinned changes whether an instance is always-on.
+func (db *DB) SetInstancePinned(id uint64, pinned bool) error {
+	result, err := db.db.Exec(`UPDATE instances SET pinned = ? WHERE id = ?`, pinned, id)
+	if err != nil {
+		return mapError(err)
+	}
+
+	return checkAffected(result)
+}
+
+func (db *DB) DeleteInstance(id uint64) error {
+	result, err := db.db.Exec(`DELETE FROM instances WHERE id = ?`, id)
+	if err != nil {
+		return mapError(err)
+	}
+
+	return checkAffected(result)
+}
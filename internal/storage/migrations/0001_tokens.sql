@@ -0,0 +1,13 @@
+CREATE TABLE tokens (
+    id            TEXT    NOT NULL PRIMARY KEY,
+    recurser_id   TEXT    NOT NULL,
+    recurser_name TEXT    NOT NULL,
+    name          TEXT    NOT NULL,
+    hash          TEXT    NOT NULL UNIQUE,
+    created       INTEGER NOT NULL,
+    expires       INTEGER NOT NULL, -- Unix seconds; 0 means the token never expires.
+    last_used     INTEGER NOT NULL,
+    UNIQUE (recurser_id, name)
+);
+
+CREATE INDEX idx_tokens_recurser_id ON tokens (recurser_id);
@@ -0,0 +1,13 @@
+CREATE TABLE instances (
+    id          INTEGER NOT NULL PRIMARY KEY, -- The Proxmox VMID.
+    recurser_id TEXT    NOT NULL,
+    name        TEXT    NOT NULL,
+    kind        TEXT    NOT NULL,
+    size        TEXT    NOT NULL,
+    template    TEXT    NOT NULL,
+    node        TEXT    NOT NULL,
+    created     INTEGER NOT NULL,
+    settings    TEXT    NOT NULL -- JSON encoded copy of the settings requested at creation.
+);
+
+CREATE INDEX idx_instances_recurser_id ON instances (recurser_id);
@@ -0,0 +1,15 @@
+CREATE TABLE tasks (
+    id          TEXT    NOT NULL PRIMARY KEY,
+    recurser_id TEXT    NOT NULL,
+    instance_id INTEGER NOT NULL, -- The Proxmox VMID of the instance the task operates on.
+    operation   TEXT    NOT NULL, -- ex. create, delete, start
+    status      TEXT    NOT NULL,
+    upid        TEXT    NOT NULL, -- The Proxmox task currently (or last) being waited on.
+    node        TEXT    NOT NULL,
+    exit_status TEXT    NOT NULL,
+    log         TEXT    NOT NULL, -- Newline separated log lines from every Proxmox task the operation ran.
+    created     INTEGER NOT NULL,
+    finished    INTEGER NOT NULL
+);
+
+CREATE INDEX idx_tasks_instance_id ON tasks (instance_id);
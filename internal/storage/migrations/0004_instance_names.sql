@@ -0,0 +1,2 @@
+-- Names are optional for instances recorded before they were introduced, so only enforce uniqueness on real names.
+CREATE UNIQUE INDEX idx_instances_name ON instances (name) WHERE name != '';
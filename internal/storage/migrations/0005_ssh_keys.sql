@@ -0,0 +1,11 @@
+CREATE TABLE ssh_keys (
+    id          TEXT    NOT NULL PRIMARY KEY,
+    recurser_id TEXT    NOT NULL,
+    name        TEXT    NOT NULL,
+    public_key  TEXT    NOT NULL, -- In authorized_keys format.
+    fingerprint TEXT    NOT NULL, -- SHA256 fingerprint as shown by ssh-keygen -l.
+    created     INTEGER NOT NULL,
+    UNIQUE (recurser_id, fingerprint)
+);
+
+CREATE INDEX idx_ssh_keys_recurser_id ON ssh_keys (recurser_id);
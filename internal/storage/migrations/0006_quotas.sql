@@ -0,0 +1,10 @@
+-- Per-recurser overrides of the default quotas in the config. Recursers without a row use the defaults.
+CREATE TABLE quotas (
+    recurser_id   TEXT    NOT NULL PRIMARY KEY,
+    max_instances INTEGER NOT NULL,
+    max_cores     INTEGER NOT NULL,
+    max_memory    INTEGER NOT NULL, -- MB
+    max_disk      INTEGER NOT NULL, -- GB
+    updated       INTEGER NOT NULL,
+    updated_by    TEXT    NOT NULL  -- Recurser ID of the admin who set the quota.
+);
@@ -0,0 +1,17 @@
+ALTER TABLE instances ADD COLUMN expires INTEGER NOT NULL DEFAULT 0; -- Unix seconds; 0 means the instance never expires.
+ALTER TABLE instances ADD COLUMN expiry_stage TEXT NOT NULL DEFAULT ''; -- How far the reaper has got with an expired instance.
+
+CREATE INDEX idx_instances_expires ON instances (expires) WHERE expires != 0;
+
+-- Things that happened to an instance that its owner should know about (ex. it is about to expire).
+CREATE TABLE instance_events (
+    id          INTEGER NOT NULL PRIMARY KEY,
+    instance_id INTEGER NOT NULL,
+    recurser_id TEXT    NOT NULL, -- The instance's owner.
+    kind        TEXT    NOT NULL,
+    message     TEXT    NOT NULL,
+    created     INTEGER NOT NULL
+);
+
+CREATE INDEX idx_instance_events_instance_id ON instance_events (instance_id);
+CREATE INDEX idx_instance_events_recurser_id ON instance_events (recurser_id);
@@ -0,0 +1,2 @@
+-- Pinned instances are always-on; they are never shut down for being idle.
+ALTER TABLE instances ADD COLUMN pinned INTEGER NOT NULL DEFAULT 0;
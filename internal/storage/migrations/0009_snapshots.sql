@@ -0,0 +1,11 @@
+-- Snapshots taken through RC3. Proxmox is the source of truth for what snapshots exist; these are kept so snapshots
+-- can be counted against their owner's limit.
+CREATE TABLE snapshots (
+    instance_id INTEGER NOT NULL,
+    name        TEXT    NOT NULL,
+    recurser_id TEXT    NOT NULL, -- The owner of the instance, who the snapshot counts against.
+    created     INTEGER NOT NULL,
+    PRIMARY KEY (instance_id, name)
+);
+
+CREATE INDEX idx_snapshots_recurser_id ON snapshots (recurser_id);
@@ -0,0 +1,32 @@
+-- Per-instance schedules for backing instances up to the backup storage.
+CREATE TABLE backup_policies (
+    instance_id INTEGER NOT NULL PRIMARY KEY,
+    recurser_id TEXT    NOT NULL, -- The owner of the instance.
+    schedule    TEXT    NOT NULL, -- "daily" or "weekly"
+    retention   INTEGER NOT NULL, -- How many scheduled backups to keep.
+    last_run    INTEGER NOT NULL, -- Unix seconds; 0 means the policy has never run.
+    created     INTEGER NOT NULL
+);
+
+-- Backups taken through RC3. They outlive the instance they were taken of so it can be restored after being deleted.
+-- IDs are never reused so a deleted backup's ID can't end up pointing at a different backup.
+CREATE TABLE backups (
+    id            INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    instance_id   INTEGER NOT NULL,
+    recurser_id   TEXT    NOT NULL, -- The owner of the instance, who the backup counts against.
+    instance_name TEXT    NOT NULL,
+    kind          TEXT    NOT NULL, -- The kind of instance: "container" or "vm"
+    size          TEXT    NOT NULL, -- The size of the instance, so restoring it can be counted against quotas.
+    node          TEXT    NOT NULL, -- The node the backup was taken on.
+    storage       TEXT    NOT NULL,
+    volume        TEXT    NOT NULL, -- The Proxmox volume ID of the backup archive.
+    bytes         INTEGER NOT NULL,
+    scheduled     INTEGER NOT NULL, -- Whether a policy took the backup; only these are pruned by retention.
+    created       INTEGER NOT NULL
+);
+
+CREATE INDEX idx_backups_instance_id ON backups (instance_id);
+CREATE INDEX idx_backups_recurser_id ON backups (recurser_id);
+
+-- GB; NULL means the default backup limit applies, which is where existing overrides start out.
+ALTER TABLE quotas ADD COLUMN max_backup INTEGER;
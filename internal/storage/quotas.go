@@ -0,0 +1,71 @@
+package storage
+
+// Quota is an admin set override of the default quota for a single recurser. A limit of 0 means no limit.
+type Quota struct {
+	RecurserID   string
+	MaxInstances int
+	MaxCores     int
+	MaxMemory    int    // MB
+	MaxDisk      int    // GB
+	MaxBackup    *int   // GB; nil means the default backup limit applies.
+	Updated      int64  // Unix seconds
+	UpdatedBy    string // Recurser ID of the admin who set the quota.
+}
+
+const quotaColumns = `recurser_id, max_instances, max_cores, max_memory, max_disk, max_backup, updated,
+	updated_by`
+
+func scanQuota(row interface{ Scan(...any) error }) (*Quota, error) {
+	var quota Quota
+	err := row.Scan(&quota.RecurserID, &quota.MaxInstances, &quota.MaxCores, &quota.MaxMemory, &quota.MaxDisk,
+		&quota.MaxBackup, &quota.Updated, &quota.UpdatedBy)
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	return &quota, nil
+}
+
+// PutQuota sets a recurser's quota, replacing any quota they already had.
+func (db *DB) PutQuota(quota *Quota) error {
+	_, err := db.db.Exec(`INSERT INTO quotas (`+quotaColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (recurser_id) DO UPDATE SET max_instances = excluded.max_instances,
+		max_cores = excluded.max_cores, max_memory = excluded.max_memory, max_disk = excluded.max_disk,
+		max_backup = excluded.max_backup, updated = excluded.updated, updated_by = excluded.updated_by`,
+		quota.RecurserID, quota.MaxInstances, quota.MaxCores, quota.MaxMemory, quota.MaxDisk, quota.MaxBackup,
+		quota.Updated, quota.UpdatedBy)
+	return mapError(err)
+}
+
+func (db *DB) GetQuota(recurserID string) (*Quota, error) {
+	return scanQuota(db.db.QueryRow(`SELECT `+quotaColumns+` FROM quotas WHERE recurser_id = ?`, recurserID))
+}
+
+// ListQuotas returns every quota override, ordered by recurser ID.
+func (db *DB) ListQuotas() ([]Quota, error) {
+	rows, err := db.db.Query(`SELECT ` + quotaColumns + ` FROM quotas ORDER BY recurser_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	quotas := []Quota{}
+	for rows.Next() {
+		quota, err := scanQuota(rows)
+		if err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, *quota)
+	}
+
+	return quotas, rows.Err()
+}
+
+func (db *DB) DeleteQuota(recurserID string) error {
+	result, err := db.db.Exec(`DELETE FROM quotas WHERE recurser_id = ?`, recurserID)
+	if err != nil {
+		return mapError(err)
+	}
+
+	return checkAffected(result)
+}
@@ -0,0 +1,41 @@
+package storage
+
+// Snapshot is a snapshot of an instance taken through RC3.
+type Snapshot struct {
+	InstanceID uint64
+	Name       string
+	RecurserID string // The owner of the instance, who the snapshot counts against.
+	Created    int64  // Unix seconds
+}
+
+func (db *DB) InsertSnapshot(snapshot *Snapshot) error {
+	_, err := db.db.Exec(`INSERT INTO snapshots (instance_id, name, recurser_id, created) VALUES (?, ?, ?, ?)`,
+		snapshot.InstanceID, snapshot.Name, snapshot.RecurserID, snapshot.Created)
+	return mapError(err)
+}
+
+// CountSnapshots returns how many snapshots count against a recurser.
+func (db *DB) CountSnapshots(recurserID string) (int, error) {
+	var count int
+	err := db.db.QueryRow(`SELECT COUNT(*) FROM snapshots WHERE recurser_id = ?`, recurserID).Scan(&count)
+	if err != nil {
+		return 0, mapError(err)
+	}
+
+	return count, nil
+}
+
+func (db *DB) DeleteSnapshot(instanceID uint64, name string) error {
+	result, err := db.db.Exec(`DELETE FROM snapshots WHERE instance_id = ? AND name = ?`, instanceID, name)
+	if err != nil {
+		return mapError(err)
+	}
+
+	return checkAffected(result)
+}
+
+// DeleteInstanceSnapshots removes every snapshot of an instance, for when the instance itself is deleted.
+func (db *DB) DeleteInstanceSnapshots(instanceID uint64) error {
+	_, err := db.db.Exec(`DELETE FROM snapshots WHERE instance_id = ?`, instanceID)
+	return mapError(err)
+}
@@ -0,0 +1,59 @@
+package storage
+
+// SSHKey is a public key a recurser has registered to be put on their instances.
+type SSHKey struct {
+	ID          string
+	RecurserID  string
+	Name        string
+	PublicKey   string // In authorized_keys format.
+	Fingerprint string
+	Created     int64 // Unix seconds
+}
+
+const sshKeyColumns = `id, recurser_id, name, public_key, fingerprint, created`
+
+func scanSSHKey(row interface{ Scan(...any) error }) (*SSHKey, error) {
+	var key SSHKey
+	err := row.Scan(&key.ID, &key.RecurserID, &key.Name, &key.PublicKey, &key.Fingerprint, &key.Created)
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	return &key, nil
+}
+
+func (db *DB) InsertSSHKey(key *SSHKey) error {
+	_, err := db.db.Exec(`INSERT INTO ssh_keys (`+sshKeyColumns+`) VALUES (?, ?, ?, ?, ?, ?)`,
+		key.ID, key.RecurserID, key.Name, key.PublicKey, key.Fingerprint, key.Created)
+	return mapError(err)
+}
+
+// ListSSHKeys returns all keys belonging to a recurser, oldest first.
+func (db *DB) ListSSHKeys(recurserID string) ([]SSHKey, error) {
+	rows, err := db.db.Query(`SELECT `+sshKeyColumns+` FROM ssh_keys WHERE recurser_id = ? ORDER BY created ASC`,
+		recurserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []SSHKey{}
+	for rows.Next() {
+		key, err := scanSSHKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (db *DB) DeleteSSHKey(recurserID, id string) error {
+	result, err := db.db.Exec(`DELETE FROM ssh_keys WHERE recurser_id = ? AND id = ?`, recurserID, id)
+	if err != nil {
+		return mapError(err)
+	}
+
+	return checkAffected(result)
+}
@@ -0,0 +1,189 @@
+// Package storage holds the state RC3 needs to keep that Proxmox can't keep for us.
+//
+// State is kept in an embedded SQLite database. The schema is managed by the numbered SQL files in the migrations
+// directory, which are applied in order on service start.
+package storage
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	// ErrEntityNotFound is returned when a lookup does not match any row.
+	ErrEntityNotFound = errors.New("storage: entity not found")
+
+	// ErrEntityExists is returned when an insert conflicts with an existing row.
+	ErrEntityExists = errors.New("storage: entity already exists")
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type DB struct {
+	db *sql.DB
+}
+
+// New opens (creating if necessary) the SQLite database at the given path.
+func New(path string) (*DB, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_journal=WAL&_fk=true&_busy_timeout=5000", path))
+	if err != nil {
+		return nil, fmt.Errorf("could not open database %q: %w", path, err)
+	}
+
+	// SQLite only allows a single writer; funnelling everything through one connection avoids "database is locked"
+	// errors under concurrent requests.
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("could not connect to database %q: %w", path, err)
+	}
+
+	return &DB{db: db}, nil
+}
+
+func (db *DB) Close() error {
+	return db.db.Close()
+}
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// Migration files are named "<version>_<description>.sql" and are applied in version order.
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := []migration{}
+
+	for _, entry := range entries {
+		versionStr, _, found := strings.Cut(entry.Name(), "_")
+		if !found {
+			return nil, fmt.Errorf("migration %q is not in the format <version>_<description>.sql", entry.Name())
+		}
+
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("migration %q has an invalid version: %w", entry.Name(), err)
+		}
+
+		contents, err := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, migration{
+			version: version,
+			name:    entry.Name(),
+			sql:     string(contents),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+
+	return migrations, nil
+}
+
+// Migrate brings the database schema up to date by applying every migration that hasn't been applied yet. Each
+// migration runs in its own transaction so a failure leaves the database at the last good version.
+//
+// It returns the names of the migrations that were applied.
+func (db *DB) Migrate() ([]string, error) {
+	_, err := db.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER NOT NULL PRIMARY KEY,
+		name    TEXT    NOT NULL,
+		applied INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("could not create migrations table: %w", err)
+	}
+
+	var current int
+	err = db.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return nil, fmt.Errorf("could not query current schema version: %w", err)
+	}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, fmt.Errorf("could not load migrations: %w", err)
+	}
+
+	applied := []string{}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		tx, err := db.db.Begin()
+		if err != nil {
+			return applied, err
+		}
+
+		if _, err := tx.Exec(m.sql); err != nil {
+			_ = tx.Rollback()
+			return applied, fmt.Errorf("could not apply migration %q: %w", m.name, err)
+		}
+
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version, name, applied) VALUES (?, ?, strftime('%s', 'now'))`,
+			m.version, m.name); err != nil {
+			_ = tx.Rollback()
+			return applied, fmt.Errorf("could not record migration %q: %w", m.name, err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return applied, fmt.Errorf("could not commit migration %q: %w", m.name, err)
+		}
+
+		applied = append(applied, m.name)
+	}
+
+	return applied, nil
+}
+
+// Converts sqlite constraint errors into our own so callers don't need to know about the driver.
+func mapError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrEntityNotFound
+	}
+
+	if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return ErrEntityExists
+	}
+
+	return err
+}
+
+// Returns ErrEntityNotFound if an update or delete didn't touch anything.
+func checkAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrEntityNotFound
+	}
+
+	return nil
+}
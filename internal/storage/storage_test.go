@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+
+	db, err := New(t.TempDir() + "/rc3.db")
+	if err != nil {
+		t.Fatalf("could not open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Migrate()
+	if err != nil {
+		t.Fatalf("could not migrate database: %v", err)
+	}
+
+	return db
+}
+
+func TestMigrate(t *testing.T) {
+	db, err := New(t.TempDir() + "/rc3.db")
+	if err != nil {
+		t.Fatalf("could not open database: %v", err)
+	}
+	defer db.Close()
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatalf("could not load migrations: %v", err)
+	}
+
+	want := []string{}
+	for _, m := range migrations {
+		want = append(want, m.name)
+	}
+
+	applied, err := db.Migrate()
+	if err != nil {
+		t.Fatalf("could not migrate database: %v", err)
+	}
+
+	if !reflect.DeepEqual(applied, want) {
+		t.Errorf("first migrate applied %v; want %v", applied, want)
+	}
+
+	applied, err = db.Migrate()
+	if err != nil {
+		t.Fatalf("could not migrate database a second time: %v", err)
+	}
+
+	if len(applied) != 0 {
+		t.Errorf("second migrate applied %v; want nothing", applied)
+	}
+}
+
+func TestInstances(t *testing.T) {
+	db := newTestDB(t)
+
+	instance := Instance{
+		ID:         100,
+		RecurserID: "1234",
+		Name:       "glowing-fern",
+		Kind:       "container",
+		Size:       "small",
+		Template:   "local:vztmpl/ubuntu.tar.zst",
+		Node:       "pve1",
+		Created:    1700000000,
+		Settings:   "{}",
+	}
+
+	err := db.InsertInstance(&instance)
+	if err != nil {
+		t.Fatalf("could not insert instance: %v", err)
+	}
+
+	got, err := db.GetInstance(instance.ID)
+	if err != nil {
+		t.Fatalf("could not get instance: %v", err)
+	}
+
+	if *got != instance {
+		t.Errorf("got instance %+v; want %+v", *got, instance)
+	}
+
+	got, err = db.GetInstanceByName(instance.Name)
+	if err != nil {
+		t.Fatalf("could not get instance by name: %v", err)
+	}
+
+	if got.ID != instance.ID {
+		t.Errorf("got instance %d by name; want %d", got.ID, instance.ID)
+	}
+
+	err = db.UpdateInstanceSize(instance.ID, "large")
+	if err != nil {
+		t.Fatalf("could not update instance size: %v", err)
+	}
+
+	err = db.SetInstancePinned(instance.ID, true)
+	if err != nil {
+		t.Fatalf("could not pin instance: %v", err)
+	}
+
+	instances, err := db.ListInstancesByRecurser(instance.RecurserID)
+	if err != nil {
+		t.Fatalf("could not list instances: %v", err)
+	}
+
+	if len(instances) != 1 || instances[0].Size != "large" || !instances[0].Pinned {
+		t.Errorf("got instances %+v; want one large pinned instance", instances)
+	}
+
+	err = db.DeleteInstance(instance.ID)
+	if err != nil {
+		t.Fatalf("could not delete instance: %v", err)
+	}
+
+	instances, err = db.ListInstances()
+	if err != nil {
+		t.Fatalf("could not list instances: %v", err)
+	}
+
+	if len(instances) != 0 {
+		t.Errorf("got instances %+v after delete; want none", instances)
+	}
+}
+
+func TestInstanceConflicts(t *testing.T) {
+	db := newTestDB(t)
+
+	err := db.InsertInstance(&Instance{ID: 100, Name: "glowing-fern"})
+	if err != nil {
+		t.Fatalf("could not insert instance: %v", err)
+	}
+
+	tests := map[string]Instance{
+		"duplicate id":   {ID: 100, Name: "quiet-river"},
+		"duplicate name": {ID: 101, Name: "glowing-fern"},
+	}
+
+	for name, instance := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := db.InsertInstance(&instance)
+			if !errors.Is(err, ErrEntityExists) {
+				t.Errorf("got error %v; want %v", err, ErrEntityExists)
+			}
+		})
+	}
+
+	// Names are only unique when set.
+	for _, id := range []uint64{102, 103} {
+		err := db.InsertInstance(&Instance{ID: id})
+		if err != nil {
+			t.Errorf("could not insert unnamed instance %d: %v", id, err)
+		}
+	}
+}
+
+func TestInstanceNotFound(t *testing.T) {
+	db := newTestDB(t)
+
+	tests := map[string]func() error{
+		"get": func() error {
+			_, err := db.GetInstance(404)
+			return err
+		},
+		"get by name": func() error {
+			_, err := db.GetInstanceByName("missing")
+			return err
+		},
+		"update expiry": func() error { return db.UpdateInstanceExpiry(404, 0, "") },
+		"update size":   func() error { return db.UpdateInstanceSize(404, "small") },
+		"pin":           func() error { return db.SetInstancePinned(404, true) },
+		"delete":        func() error { return db.DeleteInstance(404) },
+	}
+
+	for name, call := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := call()
+			if !errors.Is(err, ErrEntityNotFound) {
+				t.Errorf("got error %v; want %v", err, ErrEntityNotFound)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package storage
+
+// Task tracks a long running operation on an instance. A single task may be made up of several Proxmox tasks (ex.
+// creating a VM clones, configures and then starts it).
+type Task struct {
+	ID         string
+	RecurserID string
+	InstanceID uint64
+	Operation  string
+	Status     string
+	UPID       string
+	Node       string
+	ExitStatus string
+	Log        string
+	Created    int64 // Unix seconds
+	Finished   int64 // Unix seconds; 0 means the task is still running.
+}
+
+const taskColumns = `id, recurser_id, instance_id, operation, status, upid, node, exit_status, log, created, finished`
+
+func scanTask(row interface{ Scan(...any) error }) (*Task, error) {
+	var task Task
+	err := row.Scan(&task.ID, &task.RecurserID, &task.InstanceID, &task.Operation, &task.Status, &task.UPID,
+		&task.Node, &task.ExitStatus, &task.Log, &task.Created, &task.Finished)
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	return &task, nil
+}
+
+func (db *DB) InsertTask(task *Task) error {
+	_, err := db.db.Exec(`INSERT INTO tasks (`+taskColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		task.ID, task.RecurserID, task.InstanceID, task.Operation, task.Status, task.UPID, task.Node,
+		task.ExitStatus, task.Log, task.Created, task.Finished)
+	return mapError(err)
+}
+
+func (db *DB) GetTask(id string) (*Task, error) {
+	return scanTask(db.db.QueryRow(`SELECT `+taskColumns+` FROM tasks WHERE id = ?`, id))
+}
+
+// UpdateTask saves the progress of a task. Everything that identifies the task (who started it, on what and when)
+// is left as is.
+func (db *DB) UpdateTask(task *Task) error {
+	result, err := db.db.Exec(`UPDATE tasks SET status = ?, upid = ?, node = ?, exit_status = ?, log = ?, finished = ?
+		WHERE id = ?`, task.Status, task.UPID, task.Node, task.ExitStatus, task.Log, task.Finished, task.ID)
+	if err != nil {
+		return mapError(err)
+	}
+
+	return checkAffected(result)
+}
+
+// FailUnfinishedTasks marks every task that is still in progress as failed and returns how many there were. Tasks
+// are only tracked in memory while they run so anything unfinished at startup was interrupted by a restart.
+func (db *DB) FailUnfinishedTasks(status, exitStatus string, finished int64) (int64, error) {
+	result, err := db.db.Exec(`UPDATE tasks SET status = ?, exit_status = ?, finished = ? WHERE finished = 0`,
+		status, exitStatus, finished)
+	if err != nil {
+		return 0, mapError(err)
+	}
+
+	return result.RowsAffected()
+}
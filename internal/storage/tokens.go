@@ -0,0 +1,87 @@
+package storage
+
+// Token is a personal API token. Only the hash of the token is ever stored; the token itself is shown to the
+// recurser once on creation.
+type Token struct {
+	ID           string
+	RecurserID   string
+	RecurserName string
+	Name         string
+	Hash         string
+	Created      int64 // Unix seconds
+	Expires      int64 // Unix seconds; 0 means the token never expires.
+	LastUsed     int64 // Unix seconds; 0 means the token has never been used.
+}
+
+const tokenColumns = `id, recurser_id, recurser_name, name, hash, created, expires, last_used`
+
+func scanToken(row interface{ Scan(...any) error }) (*Token, error) {
+	var token Token
+	err := row.Scan(&token.ID, &token.RecurserID, &token.RecurserName, &token.Name, &token.Hash,
+		&token.Created, &token.Expires, &token.LastUsed)
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	return &token, nil
+}
+
+func (db *DB) InsertToken(token *Token) error {
+	_, err := db.db.Exec(`INSERT INTO tokens (`+tokenColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		token.ID, token.RecurserID, token.RecurserName, token.Name, token.Hash,
+		token.Created, token.Expires, token.LastUsed)
+	return mapError(err)
+}
+
+// ListTokens returns all tokens belonging to a recurser, newest first.
+func (db *DB) ListTokens(recurserID string) ([]Token, error) {
+	rows, err := db.db.Query(`SELECT `+tokenColumns+` FROM tokens WHERE recurser_id = ? ORDER BY created DESC`,
+		recurserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []Token{}
+	for rows.Next() {
+		token, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+
+	return tokens, rows.Err()
+}
+
+func (db *DB) GetToken(recurserID, id string) (*Token, error) {
+	return scanToken(db.db.QueryRow(`SELECT `+tokenColumns+` FROM tokens WHERE recurser_id = ? AND id = ?`,
+		recurserID, id))
+}
+
+func (db *DB) GetTokenByHash(hash string) (*Token, error) {
+	return scanToken(db.db.QueryRow(`SELECT `+tokenColumns+` FROM tokens WHERE hash = ?`, hash))
+}
+
+func (db *DB) UpdateTokenName(recurserID, id, name string) error {
+	result, err := db.db.Exec(`UPDATE tokens SET name = ? WHERE recurser_id = ? AND id = ?`, name, recurserID, id)
+	if err != nil {
+		return mapError(err)
+	}
+
+	return checkAffected(result)
+}
+
+func (db *DB) UpdateTokenLastUsed(id string, lastUsed int64) error {
+	_, err := db.db.Exec(`UPDATE tokens SET last_used = ? WHERE id = ?`, lastUsed, id)
+	return mapError(err)
+}
+
+func (db *DB) DeleteToken(recurserID, id string) error {
+	result, err := db.db.Exec(`DELETE FROM tokens WHERE recurser_id = ? AND id = ?`, recurserID, id)
+	if err != nil {
+		return mapError(err)
+	}
+
+	return checkAffected(result)
+}